@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// batchPrompt is one query of a -batch file.
+type batchPrompt struct {
+	line  int // line number in the file, for reporting failures
+	query string
+}
+
+// readBatch returns the non-blank lines of the file at path as prompts.
+func readBatch(path string) ([]batchPrompt, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var prompts []batchPrompt
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if query := strings.TrimSpace(scanner.Text()); query != "" {
+			prompts = append(prompts, batchPrompt{line: n, query: query})
+		}
+	}
+	return prompts, scanner.Err()
+}
+
+// batchResult is the outcome of one batch query.
+type batchResult struct {
+	out bytes.Buffer // the header and reply, when buffered
+	err error
+}
+
+// runBatch sends each prompt as its own one-shot query, printing every reply
+// headed by the prompt it answers. Up to concurrency queries run at once;
+// when that is more than one, replies are buffered so they are still printed
+// in file order. A failed query is reported and skipped; the returned error
+// lists the lines of all that failed, and of any that were still pending
+// when ctx ended.
+func runBatch(ctx context.Context, client *openai.Client, opts *options, prompts []batchPrompt, concurrency int) error {
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range prompts {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make([]chan *batchResult, len(prompts))
+	for i := range results {
+		results[i] = make(chan *batchResult, 1)
+	}
+	for range concurrency {
+		go func() {
+			for i := range jobs {
+				results[i] <- runBatchQuery(ctx, client, opts, prompts[i], concurrency > 1)
+			}
+		}()
+	}
+
+	var failed, unfinished []string
+	for i, p := range prompts {
+		var r *batchResult
+		select {
+		case r = <-results[i]:
+		case <-ctx.Done():
+			// Keep a reply that was already in before the end.
+			select {
+			case r = <-results[i]:
+			default:
+			}
+		}
+		if r == nil || (r.err != nil && ctx.Err() != nil) {
+			unfinished = append(unfinished, strconv.Itoa(p.line))
+			continue
+		}
+		if _, err := r.out.WriteTo(opts.out); err != nil {
+			return err
+		}
+		if r.err != nil {
+			msg := explainError(r.err, opts.model)
+			if msg == "" {
+				msg = r.err.Error()
+			}
+			if opts.logJSON {
+				slog.Error("batch query failed", "line", p.line, "error", msg)
+			} else {
+				fmt.Fprintf(os.Stderr, "line %d failed: %s\n", p.line, msg)
+			}
+			failed = append(failed, strconv.Itoa(p.line))
+		}
+	}
+	var err error
+	if len(failed) > 0 {
+		err = fmt.Errorf("%d of %d queries failed (lines %s)", len(failed), len(prompts), strings.Join(failed, ", "))
+	}
+	if len(unfinished) > 0 {
+		err = errors.Join(fmt.Errorf("%w: %d of %d queries did not finish (lines %s)", ctx.Err(), len(unfinished), len(prompts), strings.Join(unfinished, ", ")), err)
+	}
+	return err
+}
+
+// runBatchQuery sends one batch prompt, writing its reply to opts.out or,
+// when buffered, to the result for runBatch to print in turn.
+func runBatchQuery(ctx context.Context, client *openai.Client, opts *options, p batchPrompt, buffered bool) *batchResult {
+	r := &batchResult{}
+	o := *opts
+	if buffered {
+		// Parallel queries would draw their spinners over each other.
+		o.out = &r.out
+		o.spinner = false
+	}
+	if _, r.err = fmt.Fprintf(o.out, "=== %s ===\n", p.query); r.err != nil {
+		return r
+	}
+	_, r.err = send(ctx, client, &o, nil, p.query)
+	return r
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/tidwall/sjson"
+)
+
+// responseCache keeps finished completions on disk keyed by a hash of the
+// request, so asking the same thing twice is only paid for once. A nil
+// *responseCache is a disabled cache.
+type responseCache struct {
+	dir   string
+	ttl   time.Duration // how long an entry stays fresh; 0 keeps it forever
+	scope string        // who answers the requests, from cacheScope
+}
+
+// defaultEndpoint is the API the SDK talks to when no base URL is set.
+const defaultEndpoint = "https://api.openai.com/v1/"
+
+// newResponseCache returns the cache kept in the user's cache directory,
+// holding responses only for requests sent to scope.
+func newResponseCache(ttl time.Duration, scope string) (*responseCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &responseCache{dir: filepath.Join(dir, "o1"), ttl: ttl, scope: scope}, nil
+}
+
+// cacheScope names who answers a request: the endpoint, a normalized base
+// URL or "" for the default, and the organization and project billed. The
+// same request sent elsewhere is another cache entry.
+func cacheScope(endpoint, org, project string) string {
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	return endpoint + "\n" + org + "\n" + project + "\n"
+}
+
+// path returns the file holding the response to a request. The key covers
+// where it is sent and everything sent that shapes the answer, but not
+// whether it is streamed.
+func (c *responseCache) path(params openai.ChatCompletionNewParams, extra map[string]any) (string, error) {
+	body, err := requestBody(params, extra)
+	if err != nil {
+		return "", err
+	}
+	if body, err = sjson.DeleteBytes(body, "stream_options"); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(c.scope), body...))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// load returns the stored response to a request, or nil when there is none
+// or it has expired.
+func (c *responseCache) load(params openai.ChatCompletionNewParams, extra map[string]any) *openai.ChatCompletion {
+	if c == nil {
+		return nil
+	}
+	path, err := c.path(params, extra)
+	if err != nil {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || (c.ttl > 0 && time.Since(info.ModTime()) > c.ttl) {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var chatCompletion openai.ChatCompletion
+	// An unreadable entry is a miss; the next store replaces it.
+	if err := json.Unmarshal(b, &chatCompletion); err != nil || len(chatCompletion.Choices) == 0 {
+		return nil
+	}
+	return &chatCompletion
+}
+
+// store saves the response to a request. Failing to cache only costs the
+// next run an API call, so it is reported as a warning.
+func (c *responseCache) store(params openai.ChatCompletionNewParams, extra map[string]any, chatCompletion *openai.ChatCompletion) {
+	if c == nil {
+		return
+	}
+	if err := c.write(params, extra, chatCompletion); err != nil {
+		warn(fmt.Sprintf("could not cache the response: %v", err))
+	}
+}
+
+func (c *responseCache) write(params openai.ChatCompletionNewParams, extra map[string]any, chatCompletion *openai.ChatCompletion) error {
+	path, err := c.path(params, extra)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(chatCompletion)
+	if err != nil {
+		return err
+	}
+	// A temporary file of its own keeps parallel -batch queries from
+	// clobbering each other's half-written entries.
+	f, err := os.CreateTemp(c.dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+const chatHelp = "commands: /reset clears the conversation, /model NAME switches models, /quit exits"
+
+// runChat holds an interactive conversation on stdin until EOF or /quit,
+// keeping every turn in memory so each question sees the ones before it.
+// With a session name the conversation is also saved after every turn.
+func runChat(ctx context.Context, client *openai.Client, opts *options, sessionName string, history []chatMessage) error {
+	interactive := isTerminal(os.Stdin)
+	if interactive {
+		fmt.Fprintf(os.Stderr, "chatting with %s; %s\n", opts.model, chatHelp)
+	}
+
+	// Lines are read on their own goroutine so Ctrl-C is noticed even while
+	// waiting at the prompt.
+	lines := make(chan string)
+	scanErr := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		scanErr <- scanner.Err()
+		close(lines)
+	}()
+
+	for {
+		if interactive {
+			fmt.Fprint(os.Stderr, "> ")
+		}
+		var line string
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(os.Stderr)
+			return ctx.Err()
+		case l, ok := <-lines:
+			if !ok {
+				if interactive {
+					fmt.Fprintln(os.Stderr)
+				}
+				return <-scanErr
+			}
+			line = strings.TrimSpace(l)
+		}
+
+		switch cmd, arg, _ := strings.Cut(line, " "); cmd {
+		case "":
+			continue
+		case "/quit", "/exit":
+			return nil
+		case "/reset":
+			history = nil
+			if sessionName != "" {
+				if err := resetSession(sessionName); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintln(os.Stderr, "conversation cleared")
+			continue
+		case "/model":
+			model, err := resolveModel(strings.TrimSpace(arg))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			opts.model = model
+			fmt.Fprintf(os.Stderr, "now using %s\n", model)
+			continue
+		case "/help":
+			fmt.Fprintln(os.Stderr, chatHelp)
+			continue
+		}
+
+		chatCompletion, err := send(ctx, client, opts, history, line)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if msg := explainError(err, opts.model); msg != "" {
+				fmt.Fprintln(os.Stderr, msg)
+			} else {
+				fmt.Fprintf(os.Stderr, "Failed to get chat completion: %v\n", err)
+			}
+			continue
+		}
+		history = appendTurn(history, line, chatCompletion)
+		if sessionName != "" {
+			if err := saveSession(sessionName, history); err != nil {
+				return err
+			}
+		}
+	}
+}
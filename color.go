@@ -0,0 +1,20 @@
+package main
+
+import "os"
+
+// colorModes lists the values accepted by -color.
+var colorModes = []string{"auto", "always", "never"}
+
+// useColor reports whether ANSI escapes may be written to f, which is nil
+// for output that is not a standard stream. This is the one place -color
+// and NO_COLOR are checked: auto colors only a terminal, and only while
+// NO_COLOR is unset.
+func useColor(mode string, f *os.File) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return os.Getenv("NO_COLOR") == "" && f != nil && isTerminal(f)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// send runs one turn of a conversation: it asks the model to answer query
+// after history, prints the reply and returns the finished completion.
+func send(ctx context.Context, client *openai.Client, opts *options, history []chatMessage, query string) (chatCompletion *openai.ChatCompletion, err error) {
+	start := time.Now()
+	retries, cached := 0, false
+	// The first streamed token arrives as the first write to timed.
+	out := newOutput(opts)
+	timed := &timedWriter{Writer: out}
+	firstToken := func() time.Duration {
+		if timed.first.IsZero() {
+			return 0
+		}
+		return timed.first.Sub(start)
+	}
+	if opts.logJSON {
+		defer func() {
+			logRequest(opts.model, time.Since(start), firstToken(), retries, cached, chatCompletion, err)
+		}()
+	}
+
+	params := newParams(opts, history, query)
+	chatCompletion = opts.cache.load(params, opts.extra)
+	cached = chatCompletion != nil
+	if cached {
+		if !opts.logJSON {
+			fmt.Fprintln(notices, "(cached)")
+		}
+	} else {
+		cacheParams := params
+		chatCompletion, retries, err = completeWithRetry(ctx, client, opts, params, timed)
+		if err != nil && opts.system != "" && isSystemRoleRejected(err) {
+			inform(fmt.Sprintf("%s does not accept system messages, sending the instruction with the query instead", opts.model), "model", opts.model)
+			opts.systemRejected.Store(true)
+			params = newParams(opts, history, query)
+			var more int
+			chatCompletion, more, err = completeWithRetry(ctx, client, opts, params, timed)
+			retries += more
+		}
+		// A reply that breaks the -response-format is not kept, so asking
+		// again gets a fresh one.
+		if err == nil && chatCompletion != nil && len(chatCompletion.Choices) > 0 &&
+			(!opts.request.ResponseFormat.Present || checkJSONReply(chatCompletion, opts.request.N.Present) == nil) {
+			opts.cache.store(cacheParams, opts.extra, chatCompletion)
+		}
+	}
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Everything after this point reads Choices[0], so an empty response
+	// must stop here rather than panic.
+	if chatCompletion == nil || len(chatCompletion.Choices) == 0 {
+		return nil, errors.New("no completions returned")
+	}
+	if msg := chatCompletion.Choices[0].Message; msg.Content == "" && msg.Refusal != "" {
+		return nil, &refusalError{msg.Refusal}
+	}
+	if !opts.stream || cached {
+		if err := printResponse(out, chatCompletion, opts); err != nil {
+			return nil, err
+		}
+	}
+	// The reply is printed even when it is not the JSON asked for, so the
+	// caller can see what came back.
+	if opts.request.ResponseFormat.Present {
+		if err := checkJSONReply(chatCompletion, opts.request.N.Present); err != nil {
+			return nil, err
+		}
+	}
+	// The request record already carries the rest of what follows.
+	if opts.logJSON {
+		return chatCompletion, nil
+	}
+	// Read the clock before printing adds to it.
+	elapsed := time.Since(start)
+	if chatCompletion.Choices[0].FinishReason == openai.ChatCompletionChoicesFinishReasonLength {
+		fmt.Fprintln(notices, "response cut off at the completion token limit")
+	}
+	if opts.usage && !cached {
+		printUsage(notices, opts.model, chatCompletion.Usage)
+	}
+	if opts.timing && !cached {
+		printTiming(notices, firstToken(), elapsed, retries)
+	}
+	if opts.fingerprint {
+		printFingerprint(notices, chatCompletion.SystemFingerprint, opts.request.Seed.Present)
+	}
+	return chatCompletion, nil
+}
+
+// newParams builds the request asking the model to answer query after history.
+func newParams(opts *options, history []chatMessage, query string) openai.ChatCompletionNewParams {
+	params := opts.request
+	system := opts.system
+	if system != "" && opts.systemRejected.Load() {
+		system, query = "", system+"\n\n"+query
+	}
+	params.Messages = openai.F(buildMessages(system, history, query, opts.images))
+	params.Model = openai.F(opts.model)
+	if opts.stream && (opts.usage || opts.logJSON) {
+		params.StreamOptions = openai.F(openai.ChatCompletionStreamOptionsParam{
+			IncludeUsage: openai.F(true),
+		})
+	}
+	return params
+}
+
+// printResponse writes a buffered completion to out, as a JSON object with
+// opts.json. When opts asked for several choices every one is printed, each
+// headed by its number, instead of only the first.
+func printResponse(out outputWriter, chatCompletion *openai.ChatCompletion, opts *options) error {
+	all := opts.request.N.Present
+	if opts.json {
+		return writeJSON(out, chatCompletion, all)
+	}
+	choices := chatCompletion.Choices
+	if !all {
+		choices = choices[:1]
+	}
+	for i, choice := range choices {
+		if all {
+			if _, err := fmt.Fprintf(out, "=== choice %d ===\n", i+1); err != nil {
+				return err
+			}
+		}
+		content := choice.Message.Content
+		if opts.trim {
+			content = trimContent(content)
+		}
+		if _, err := fmt.Fprint(out, content+"\n"); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// buildMessages assembles the conversation sent for a query: the system
+// instruction when there is one, any earlier turns, then the query itself
+// followed by any images, given as URLs.
+func buildMessages(system string, history []chatMessage, query string, images []string) []openai.ChatCompletionMessageParamUnion {
+	var messages []openai.ChatCompletionMessageParamUnion
+	if system != "" {
+		messages = append(messages, openai.SystemMessage(system))
+	}
+	for _, m := range history {
+		messages = append(messages, m.param())
+	}
+	if len(images) == 0 {
+		return append(messages, openai.UserMessage(query))
+	}
+	parts := []openai.ChatCompletionContentPartUnionParam{openai.TextPart(query)}
+	for _, url := range images {
+		parts = append(parts, openai.ImagePart(url))
+	}
+	return append(messages, openai.UserMessageParts(parts...))
+}
+
+// apiErrorBody is the error object the API sends with a failed request.
+type apiErrorBody struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Param   string `json:"param"`
+	Code    string `json:"code"`
+}
+
+// apiErrorDetail returns the error object of a failed request. The API nests
+// it under "error", which the SDK leaves wrapped, so the fields of
+// openai.Error itself are usually empty.
+func apiErrorDetail(apiErr *openai.Error) apiErrorBody {
+	var body struct {
+		Error apiErrorBody `json:"error"`
+	}
+	// A partly decoded body is still the best detail available.
+	_ = json.Unmarshal([]byte(apiErr.JSON.RawJSON()), &body)
+	if body.Error.Message == "" {
+		return apiErrorBody{Message: apiErr.Message, Type: apiErr.Type, Param: apiErr.Param, Code: apiErr.Code}
+	}
+	return body.Error
+}
+
+// refusalError reports that the model declined to answer.
+type refusalError struct {
+	refusal string
+}
+
+func (e *refusalError) Error() string {
+	return "model refused: " + e.refusal
+}
+
+// explainError returns a message for the failures the user can act on
+// without the raw API error, or "" when there is nothing better to say.
+func explainError(err error, model openai.ChatModel) string {
+	var refusal *refusalError
+	if errors.As(err, &refusal) {
+		return refusal.Error()
+	}
+	return paramHint(err, model)
+}
+
+// paramFlags names the flag that sets each optional request field.
+var paramFlags = map[string]string{
+	"temperature":           "-temperature",
+	"top_p":                 "-top-p",
+	"max_completion_tokens": "-max-tokens",
+	"reasoning_effort":      "-effort",
+	"seed":                  "-seed",
+	"n":                     "-n",
+	"stop":                  "-stop",
+	"response_format":       "-response-format",
+	"logit_bias":            "-logit-bias",
+	"frequency_penalty":     "-frequency-penalty",
+	"presence_penalty":      "-presence-penalty",
+}
+
+// paramHint explains an API rejection of a request field set by one of our
+// flags, or returns "" for any other error.
+func paramHint(err error, model openai.ChatModel) string {
+	var apiErr *openai.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		return ""
+	}
+	detail := apiErrorDetail(apiErr)
+	name, ok := paramFlags[detail.Param]
+	if !ok {
+		return ""
+	}
+	if detail.Code == "unsupported_parameter" || detail.Code == "unsupported_value" {
+		return fmt.Sprintf("%s does not support %s (%s)\nleave %s unset for this model", model, name, detail.Message, name)
+	}
+	return fmt.Sprintf("%s rejected %s: %s", model, name, detail.Message)
+}
+
+// isSystemRoleRejected reports whether err is the API refusing a system
+// message, which o1-mini and o1-preview do.
+func isSystemRoleRejected(err error) bool {
+	var apiErr *openai.Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest && strings.HasSuffix(apiErrorDetail(apiErr).Param, ".role")
+}
+
+// complete sends params and returns the finished completion. When stream is
+// not nil the reply is also written to it as it arrives.
+func complete(ctx context.Context, client *openai.Client, params openai.ChatCompletionNewParams, stream io.Writer, reqOpts ...option.RequestOption) (*openai.ChatCompletion, error) {
+	if stream != nil {
+		return streamCompletion(ctx, client, stream, params, reqOpts...)
+	}
+	return client.Chat.Completions.New(ctx, params, reqOpts...)
+}
+
+// extraFieldOptions sets request fields that this SDK version has no params
+// for directly in the request body.
+func extraFieldOptions(extra map[string]any) []option.RequestOption {
+	var reqOpts []option.RequestOption
+	for _, key := range slices.Sorted(maps.Keys(extra)) {
+		reqOpts = append(reqOpts, option.WithJSONSet(key, extra[key]))
+	}
+	return reqOpts
+}
+
+// streamCompletion writes the response to w chunk by chunk as it arrives.
+// A stream that breaks off before the model reports a finish reason is
+// returned as an error so a truncated answer is never mistaken for a full one.
+func streamCompletion(ctx context.Context, client *openai.Client, w io.Writer, params openai.ChatCompletionNewParams, reqOpts ...option.RequestOption) (*openai.ChatCompletion, error) {
+	stream := client.Chat.Completions.NewStreaming(ctx, params, reqOpts...)
+	// A request that failed before the stream opened has nothing to close.
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	acc := openai.ChatCompletionAccumulator{}
+	wrote := false
+	for stream.Next() {
+		chunk := stream.Current()
+		acc.AddChunk(chunk)
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			if _, err := io.WriteString(w, chunk.Choices[0].Delta.Content); err != nil {
+				return nil, err
+			}
+			wrote = true
+		}
+	}
+	if wrote {
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(acc.Choices) == 0 {
+		return nil, errors.New("no completions returned")
+	}
+	if acc.Choices[0].FinishReason == "" {
+		return nil, errors.New("stream ended before the response was complete")
+	}
+	return &acc.ChatCompletion, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// config holds the defaults read from the config file. Every field is
+// optional; a value given on the command line takes precedence.
+type config struct {
+	Model       string   `json:"model"`
+	Timeout     string   `json:"timeout"` // a duration such as "90s" or "5m"
+	System      string   `json:"system"`
+	Temperature *float64 `json:"temperature"`
+	Retries     *int     `json:"retries"`
+	Cache       *bool    `json:"cache"`
+}
+
+// configDir returns the directory the tool keeps its settings and sessions in.
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "o1"), nil
+}
+
+// loadConfig reads the config file at path, or config.json in configDir when
+// path is empty. A missing default file is the same as an empty one.
+func loadConfig(path string) (*config, error) {
+	explicit := path != ""
+	if !explicit {
+		dir, err := configDir()
+		if err != nil {
+			return &config{}, nil
+		}
+		path = filepath.Join(dir, "config.json")
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return &config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// flagValues returns the settings in c keyed by the flag each one defaults,
+// in the form that flag would be given on the command line.
+func (c *config) flagValues() map[string]string {
+	values := map[string]string{}
+	if c.Model != "" {
+		values["model"] = c.Model
+	}
+	if c.Timeout != "" {
+		values["timeout"] = c.Timeout
+	}
+	if c.System != "" {
+		values["system"] = c.System
+	}
+	if c.Temperature != nil {
+		values["temperature"] = strconv.FormatFloat(*c.Temperature, 'g', -1, 64)
+	}
+	if c.Retries != nil {
+		values["retries"] = strconv.Itoa(*c.Retries)
+	}
+	if c.Cache != nil {
+		values["cache"] = strconv.FormatBool(*c.Cache)
+	}
+	return values
+}
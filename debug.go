@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go/option"
+)
+
+// redactedHeaders carry credentials and are never printed by -debug.
+var redactedHeaders = []string{"Authorization", "Api-Key"}
+
+// debugMiddleware logs every HTTP exchange with the API to w in the style
+// of curl -v: the request line and headers, then the response status,
+// latency and headers. A successful completion whose body does not have the
+// shape the SDK decodes is printed in full, so a parse error comes with the
+// body that caused it.
+func debugMiddleware(w io.Writer) option.Middleware {
+	return func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		start := time.Now()
+		fmt.Fprintf(w, "> %s %s\n", req.Method, req.URL)
+		writeHeaders(w, "> ", req.Header)
+		res, err := next(req)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			fmt.Fprintf(w, "< failed after %v: %v\n", elapsed, err)
+			return res, err
+		}
+		fmt.Fprintf(w, "< %s in %v\n", res.Status, elapsed)
+		writeHeaders(w, "< ", res.Header)
+		// Error bodies are already part of the error the SDK returns.
+		if res.StatusCode >= 300 || !strings.HasSuffix(req.URL.Path, "/chat/completions") {
+			return res, nil
+		}
+		if strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+			// A stream is checked once read, so it still arrives as it comes.
+			res.Body = &checkedStream{ReadCloser: res.Body, w: w}
+			return res, nil
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return res, err
+		}
+		if !isCompletionBody(body) {
+			writeMalformedBody(w, body)
+		}
+		return res, nil
+	}
+}
+
+// checkedStream keeps a copy of a streamed completion as it is read and
+// prints it when closed if any event was not valid JSON.
+type checkedStream struct {
+	io.ReadCloser
+	w    io.Writer
+	body bytes.Buffer
+}
+
+func (s *checkedStream) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	s.body.Write(p[:n])
+	return n, err
+}
+
+func (s *checkedStream) Close() error {
+	for _, line := range strings.Split(s.body.String(), "\n") {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		if data = strings.TrimSpace(data); ok && data != "[DONE]" && !json.Valid([]byte(data)) {
+			writeMalformedBody(s.w, s.body.Bytes())
+			break
+		}
+	}
+	return s.ReadCloser.Close()
+}
+
+// isCompletionBody reports whether body is a JSON object with a list of
+// choices, the least a completion needs to be decoded.
+func isCompletionBody(body []byte) bool {
+	var completion struct {
+		Choices []json.RawMessage `json:"choices"`
+	}
+	return json.Unmarshal(body, &completion) == nil && completion.Choices != nil
+}
+
+// writeMalformedBody prints a response body as received, unredacted.
+func writeMalformedBody(w io.Writer, body []byte) {
+	fmt.Fprintf(w, "< unexpected response body (%d bytes):\n%s\n", len(body), body)
+}
+
+// writeHeaders prints h sorted by name, with credentials redacted.
+func writeHeaders(w io.Writer, prefix string, h http.Header) {
+	for _, name := range slices.Sorted(maps.Keys(h)) {
+		value := strings.Join(h[name], ", ")
+		if slices.Contains(redactedHeaders, http.CanonicalHeaderKey(name)) {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(w, "%s%s: %s\n", prefix, name, value)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// fallbackEditors are tried in order when $EDITOR is not set.
+var fallbackEditors = []string{"vi", "nano"}
+
+// editPrompt opens an empty temporary file in the user's editor, waits for
+// it to exit and returns what was saved. The editor runs on the terminal
+// even when stdin or stdout are redirected.
+func editPrompt() (string, error) {
+	f, err := os.CreateTemp("", "o1-prompt-*.md")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	defer os.Remove(path)
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	editor := strings.TrimSpace(os.Getenv("EDITOR"))
+	if editor == "" {
+		for _, name := range fallbackEditors {
+			if _, err := exec.LookPath(name); err == nil {
+				editor = name
+				break
+			}
+		}
+	}
+	if editor == "" {
+		return "", errors.New("no editor found; set $EDITOR")
+	}
+
+	// $EDITOR is a shell command, as git treats it, so it may hold quoted
+	// paths and arguments; the file is passed after them.
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	}
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envArgsVar names the environment variable holding default flags.
+const envArgsVar = "O1_ARGS"
+
+// parseArgs parses the flags in $O1_ARGS, then those on the command line,
+// so a flag given on the command line overrides the same flag from the
+// environment. Flags that may be repeated collect values from both.
+func parseArgs() error {
+	envArgs, err := splitArgs(os.Getenv(envArgsVar))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envArgsVar, err)
+	}
+	if err := flag.CommandLine.Parse(envArgs); err != nil {
+		return err
+	}
+	// A positional argument would end flag parsing before the command
+	// line's own flags were reached.
+	if flag.NArg() > 0 {
+		return fmt.Errorf("invalid %s: it may only hold flags, found %q", envArgsVar, flag.Arg(0))
+	}
+	return flag.CommandLine.Parse(os.Args[1:])
+}
+
+// splitArgs splits s into words the way a POSIX shell would, minus
+// expansions: words are separated by whitespace, single quotes keep
+// everything literally, double quotes keep everything but backslash
+// escapes of ", \, $ and ` and escaped newlines, and a backslash outside
+// quotes escapes the next character.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			// An escaped newline continues the line, as in a shell, and
+			// starts no word of its own.
+			if s[i] != '\n' {
+				word.WriteByte(s[i])
+				inWord = true
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					switch s[i+1] {
+					case '\n':
+						// A line continuation disappears altogether.
+						i++
+						continue
+					case '"', '\\', '$', '`':
+						i++
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
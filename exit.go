@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/openai/openai-go"
+)
+
+// Exit statuses, distinct per kind of failure so scripts can react to a
+// rate limit differently from a bad key.
+const (
+	exitFailure   = 1 // any failure not listed below
+	exitUsage     = 2 // invalid flags or arguments
+	exitAuth      = 3 // missing or rejected API key
+	exitRateLimit = 4 // rate limited or out of quota
+	exitTimeout   = 5 // timed out or cancelled
+	exitBinary    = 6 // piped input is binary rather than text
+)
+
+// exitCode returns the exit status for a failed request.
+func exitCode(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return exitTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return exitTimeout
+	}
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return exitAuth
+		case http.StatusTooManyRequests:
+			return exitRateLimit
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			return exitTimeout
+		}
+	}
+	return exitFailure
+}
@@ -3,9 +3,15 @@ module github.com/gkeiser/o1
 go 1.23.2
 
 require (
-	github.com/openai/openai-go v0.1.0-alpha.39 // indirect
+	github.com/alecthomas/chroma/v2 v2.23.1
+	github.com/openai/openai-go v0.1.0-alpha.39
+	github.com/tidwall/sjson v1.2.5
+	github.com/tiktoken-go/tokenizer v0.7.0
+)
+
+require (
+	github.com/dlclark/regexp2 v1.11.5 // indirect
 	github.com/tidwall/gjson v1.14.4 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
-	github.com/tidwall/sjson v1.2.5 // indirect
 )
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// highlighter colorizes the fenced code blocks of a markdown reply and
+// passes prose through untouched. Code is held back until its closing fence
+// so the lexer sees the whole block, but prose is written as soon as it is
+// clearly not a fence, so streamed replies still appear as they arrive.
+type highlighter struct {
+	w       io.Writer
+	line    []byte // the current line, not yet ended by a newline
+	written int    // how much of line has already been written through
+	inCode  bool
+	fence   string // the opening fence of the current code block
+	lang    string
+	code    strings.Builder
+}
+
+func newHighlighter(w io.Writer) *highlighter {
+	return &highlighter{w: w}
+}
+
+func (h *highlighter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			h.line = append(h.line, p...)
+			break
+		}
+		h.line = append(h.line, p[:i+1]...)
+		p = p[i+1:]
+		if err := h.endLine(); err != nil {
+			return 0, err
+		}
+	}
+	if !h.inCode && !mayBeFence(h.line) {
+		if _, err := h.w.Write(h.line[h.written:]); err != nil {
+			return 0, err
+		}
+		h.written = len(h.line)
+	}
+	return n, nil
+}
+
+// endLine handles a complete line, opening or closing a code block on fences.
+func (h *highlighter) endLine() error {
+	line, written := h.line, h.written
+	h.line, h.written = h.line[:0:0], 0
+	trimmed := strings.TrimSpace(string(line))
+	switch {
+	case h.inCode && strings.HasPrefix(trimmed, h.fence) && strings.Trim(trimmed, h.fence[:1]) == "":
+		h.inCode = false
+		if err := h.flushCode(); err != nil {
+			return err
+		}
+	case h.inCode:
+		h.code.Write(line)
+		return nil
+	case written == 0 && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")):
+		h.inCode = true
+		info := strings.TrimLeft(trimmed, trimmed[:1])
+		h.fence = trimmed[:len(trimmed)-len(info)]
+		h.lang = ""
+		if fields := strings.Fields(info); len(fields) > 0 {
+			h.lang = fields[0]
+		}
+	}
+	_, err := h.w.Write(line[written:])
+	return err
+}
+
+// flushCode writes the buffered code block, colorized for its language.
+func (h *highlighter) flushCode() error {
+	code := h.code.String()
+	h.code.Reset()
+	lexer := lexers.Get(h.lang)
+	if lexer == nil {
+		lexer = lexers.Analyse(code)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		_, err = io.WriteString(h.w, code)
+		return err
+	}
+	return formatters.TTY256.Format(h.w, styles.Get("monokai"), tokens)
+}
+
+// Flush writes whatever is still held back, such as a code block whose
+// closing fence never arrived.
+func (h *highlighter) Flush() error {
+	if h.inCode {
+		h.code.Write(h.line)
+		h.line = h.line[:0]
+		h.inCode = false
+		if err := h.flushCode(); err != nil {
+			return err
+		}
+	}
+	_, err := h.w.Write(h.line[h.written:])
+	h.line, h.written = h.line[:0], 0
+	return err
+}
+
+// mayBeFence reports whether an unfinished line could still turn out to be
+// a code fence of backticks or tildes, in which case it is held back until
+// it ends.
+func mayBeFence(line []byte) bool {
+	t := bytes.TrimLeft(line, " \t")
+	if len(t) >= 3 {
+		return bytes.HasPrefix(t, []byte("```")) || bytes.HasPrefix(t, []byte("~~~"))
+	}
+	return len(bytes.Trim(t, "`")) == 0 || len(bytes.Trim(t, "~")) == 0
+}
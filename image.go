@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// imageTypes lists the image formats the API accepts.
+var imageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// maxImageSize is the largest image the API accepts.
+const maxImageSize = 20 << 20
+
+// readImages returns the images at paths as base64 data URLs for image
+// content parts. The type is told from the file's contents, not its name.
+func readImages(paths []string) ([]string, error) {
+	var urls []string
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > maxImageSize {
+			return nil, fmt.Errorf("%s is larger than the %d MB the API accepts", path, maxImageSize>>20)
+		}
+		mediaType := http.DetectContentType(b)
+		if !isImageType(mediaType) {
+			return nil, fmt.Errorf("%s is not a supported image (%s), accepted: png, jpeg, gif, webp", path, mediaType)
+		}
+		urls = append(urls, "data:"+mediaType+";base64,"+base64.StdEncoding.EncodeToString(b))
+	}
+	return urls, nil
+}
+
+func isImageType(mediaType string) bool {
+	for _, t := range imageTypes {
+		if strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
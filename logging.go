@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// logFormats lists the values accepted by -log-format.
+var logFormats = []string{"text", "json"}
+
+// notices is where warnings and progress notes are written: stderr, unless
+// -quiet discards them. The errors the tool fails on always reach stderr.
+var notices io.Writer = diagnostics
+
+// diagnostics passes notices and log records on to stderr, or keeps them
+// back while held.
+var diagnostics = &holdWriter{w: os.Stderr}
+
+// holdWriter writes to w, except that while held it collects the writes
+// instead, so they do not land on a screen the pager owns.
+type holdWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	held *bytes.Buffer
+}
+
+func (h *holdWriter) Write(b []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.held != nil {
+		return h.held.Write(b)
+	}
+	return h.w.Write(b)
+}
+
+// hold collects writes until release is called, which writes them out.
+func (h *holdWriter) hold() (release func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.held = new(bytes.Buffer)
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.w.Write(h.held.Bytes())
+		h.held = nil
+	}
+}
+
+// jsonLogs is set by useJSONLogging, after which notices are slog records.
+var jsonLogs bool
+
+// warn reports a problem the run carries on past, as "warning: " and text
+// on notices, or under -log-format json as a warning record with text as
+// its message and args as its attributes.
+func warn(text string, args ...any) {
+	if jsonLogs {
+		slog.Warn(text, args...)
+		return
+	}
+	fmt.Fprintln(notices, "warning: "+text)
+}
+
+// inform reports what the tool did on the user's behalf, like warn but at
+// the info level and without the prefix.
+func inform(text string, args ...any) {
+	if jsonLogs {
+		slog.Info(text, args...)
+		return
+	}
+	fmt.Fprintln(notices, text)
+}
+
+// useJSONLogging sends diagnostics to stderr as slog JSON records, those
+// of the log package included, for automation to parse. Replies still go
+// to stdout on their own. With quiet only errors are recorded.
+func useJSONLogging(quiet bool) {
+	level := slog.LevelInfo
+	if quiet {
+		level = slog.LevelError
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(diagnostics, &slog.HandlerOptions{Level: level})))
+	// The log package is only used for the errors the tool exits on.
+	slog.SetLogLoggerLevel(slog.LevelError)
+	jsonLogs = true
+}
+
+// logRequest records the outcome of one turn for -log-format json.
+// firstToken is how long the first token of a streamed reply took, or 0.
+func logRequest(model openai.ChatModel, elapsed, firstToken time.Duration, retries int, cached bool, chatCompletion *openai.ChatCompletion, err error) {
+	attrs := []any{
+		slog.String("model", string(model)),
+		slog.Int64("duration_ms", elapsed.Milliseconds()),
+		slog.Int("retries", retries),
+		slog.Bool("cached", cached),
+	}
+	if firstToken > 0 {
+		attrs = append(attrs, slog.Int64("first_token_ms", firstToken.Milliseconds()))
+	}
+	if err != nil {
+		slog.Error("request failed", append(attrs, slog.String("error", err.Error()))...)
+		return
+	}
+	usage := chatCompletion.Usage
+	slog.Info("request finished", append(attrs,
+		slog.String("finish_reason", string(chatCompletion.Choices[0].FinishReason)),
+		slog.Int64("prompt_tokens", usage.PromptTokens),
+		slog.Int64("completion_tokens", usage.CompletionTokens),
+		slog.Int64("reasoning_tokens", usage.CompletionTokensDetails.ReasoningTokens),
+		slog.Int64("total_tokens", usage.TotalTokens),
+		slog.String("system_fingerprint", chatCompletion.SystemFingerprint),
+	)...)
+}
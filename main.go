@@ -2,61 +2,1026 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
 )
 
+// maxStops is the most stop sequences the API accepts in one request.
+const maxStops = 4
+
+// options holds the settings that shape every request and how its reply is
+// printed, whether it is a one-shot query or a turn in -chat.
+type options struct {
+	model      openai.ChatModel
+	system     string
+	request    openai.ChatCompletionNewParams // optional fields; messages and model are filled per turn
+	extra      map[string]any                 // request fields the SDK has no params for
+	timeout    time.Duration
+	retries    int
+	maxBackoff time.Duration
+	stream     bool
+	json       bool
+	usage      bool
+	highlight  bool
+	plain      bool      // strip markdown decoration from replies
+	trim       bool      // tidy the blank lines of buffered replies
+	out        io.Writer // where replies are printed: stdout, or the -o file
+	cache      *responseCache
+	images     []string // data URLs of the -image files, sent with the query
+	spinner    bool     // show progress on stderr while a buffered reply is awaited
+	// fingerprint prints the system_fingerprint of each reply, to tell
+	// whether runs with the same -seed hit the same backend.
+	fingerprint bool
+	// timing prints the time to the first streamed token and in total.
+	timing bool
+	// logJSON writes diagnostics as slog records instead of text.
+	logJSON bool
+	// systemRejected is set once the model refuses system messages, so
+	// every later request sends the instruction with the query instead. It
+	// is shared by the copies runBatchQuery makes.
+	systemRejected *atomic.Bool
+}
+
 func main() {
-	if len(os.Args) < 2 {
+	stream := flag.Bool("stream", isTerminal(os.Stdout), "print the response as it arrives (default on when stdout is a terminal)")
+	modelName := flag.String("model", openai.ChatModelO1Mini, "model to use: "+strings.Join(modelNames(), ", ")+", or any other name the endpoint serves")
+	systemPrompt := flag.String("system", "", "system instruction sent ahead of the query")
+	systemFile := flag.String("system-file", "", "read the system instruction from this file")
+	jsonOut := flag.Bool("json", false, "print the response, finish reason and token usage as a JSON object, or an array of them with -n")
+	showUsage := flag.Bool("usage", false, "print token usage and an estimated cost to stderr")
+	timing := flag.Bool("timing", false, "print the time to the first token of a streamed reply and the total time to stderr")
+	sessionName := flag.String("session", "", "keep the conversation in the named session and continue it on later runs")
+	continueLast := flag.Bool("continue", false, "follow up on the last query, in the session it was kept in ("+lastSession+" when it had no -session)")
+	sessionImport := flag.String("session-import", "", "start the conversation with the turns in this JSON file, added to any -session history")
+	reset := flag.Bool("reset", false, "clear the -session history before sending")
+	trim := flag.Bool("trim", true, "drop blank lines around a buffered reply and collapse long runs of them; code blocks are kept as written")
+	plain := flag.Bool("plain", !isTerminal(os.Stdout), "strip markdown decoration from the reply; on by default when stdout is not a terminal")
+	usePager := flag.Bool("pager", false, "show the reply in $PAGER (default less -R) when stdout is a terminal; not with -json or -quiet")
+	highlight := flag.Bool("highlight", false, "colorize fenced code blocks when -color allows it")
+	color := flag.String("color", "auto", "when to use colors and other terminal escapes: "+strings.Join(colorModes, ", ")+"; auto means on a terminal unless NO_COLOR is set")
+	estimate := flag.Bool("estimate", false, "print a local estimate of the prompt tokens before sending; -usage does too")
+	warnTokens := flag.Int("warn-tokens", 100000, "ask before sending a prompt estimated above this many tokens; 0 never asks")
+	yes := flag.Bool("yes", false, "send prompts over -warn-tokens without asking")
+	maxInput := flag.Int64("max-input", 1<<20, "largest piped input to read, in bytes; 0 means no limit")
+	truncate := flag.Bool("truncate", false, "cut piped input at -max-input instead of failing")
+	forceBinary := flag.Bool("force-binary", false, "send piped input that looks binary or is not valid UTF-8 instead of refusing it, with the bytes that cannot be sent replaced")
+	lineNumbers := flag.Bool("line-numbers", false, "number the lines of piped input, for asking about specific lines")
+	logFormat := flag.String("log-format", "text", "how diagnostics on stderr are written: "+strings.Join(logFormats, ", "))
+	var debug bool
+	quiet := flag.Bool("quiet", false, "print only the reply, and errors the run fails on; no progress, usage or warnings")
+	flag.BoolVar(&debug, "debug", false, "log each HTTP request and response to stderr, with credentials redacted")
+	flag.BoolVar(&debug, "v", false, "shorthand for -debug")
+	var outputPath string
+	flag.StringVar(&outputPath, "o", "", "write the response to this file instead of stdout")
+	flag.StringVar(&outputPath, "output", "", "same as -o")
+	force := flag.Bool("force", false, "let -o overwrite an existing file")
+	pingOnly := flag.Bool("ping", false, "check that the API key and endpoint work, then exit")
+	dryRun := flag.Bool("dry-run", false, "print the request as JSON instead of sending it")
+	chat := flag.Bool("chat", false, "chat interactively, reading one turn per line from stdin")
+	edit := flag.Bool("edit", false, "write the query in $EDITOR; a query argument is put before it")
+	raw := flag.Bool("raw", false, "send piped input verbatim, joined to the query by a newline rather than -sep")
+	prefix := flag.String("prefix", "", "wrap the input in <PREFIX> and </PREFIX> lines so the model can tell it from the query")
+	sep := flag.String("sep", ": ", "separator between the query argument and the input")
+	templatePath := flag.String("template", "", "build the query from this text/template file, given .Args, .Input (stdin) and .Env")
+	var imagePaths stringList
+	flag.Var(&imagePaths, "image", "attach this image to the query; may be repeated")
+	useCache := flag.Bool("cache", false, "answer repeated requests from the response cache")
+	noCache := flag.Bool("no-cache", false, "bypass the response cache even when the config enables it")
+	cacheTTL := flag.Duration("cache-ttl", 24*time.Hour, "how long a cached response stays fresh; 0 keeps it forever")
+	watchPath := flag.String("watch", "", "send the query with this file as input, then again each time the file changes")
+	watchInterval := flag.Duration("watch-interval", 500*time.Millisecond, "how often -watch checks the file for changes")
+	batchPath := flag.String("batch", "", "send each line of this file as a separate query")
+	concurrency := flag.Int("concurrency", 1, "how many -batch queries to send at once")
+	deadline := flag.Duration("deadline", 0, "stop everything after this long, across retries and -batch queries; 0 means no limit")
+	timeout := flag.Duration("timeout", 60*time.Second, "how long to wait for a response, e.g. 90s or 5m; 0 waits indefinitely")
+	retries := flag.Int("retries", 3, "how many times to retry rate limits and server errors")
+	maxBackoff := flag.Duration("max-backoff", 30*time.Second, "longest wait between retries")
+	temperature := flag.Float64("temperature", 1, "sampling temperature, sent only when set; o1 models accept only the default")
+	topP := flag.Float64("top-p", 1, "nucleus sampling probability mass, sent only when set; o1 models accept only the default")
+	frequencyPenalty := flag.Float64("frequency-penalty", 0, "penalize tokens by how often they already appear, from -2 to 2; sent only when set")
+	presencePenalty := flag.Float64("presence-penalty", 0, "penalize tokens that already appear at all, from -2 to 2; sent only when set")
+	responseFormat := flag.String("response-format", "text", "ask for the reply as "+strings.Join(responseFormats, " or ")+"; json fails if the reply does not parse")
+	schemaPath := flag.String("schema", "", "ask for JSON following the JSON Schema in this file; implies -response-format json")
+	choices := flag.Int64("n", 1, "how many alternative responses to ask for")
+	var stops stringList
+	flag.Var(&stops, "stop", "stop generating at this sequence; may be repeated or comma-separated, up to 4; o1 models may reject it")
+	var logitBias stringList
+	flag.Var(&logitBias, "logit-bias", "make a token more or less likely, as TOKEN:BIAS with a bias from -100 to 100; may be repeated or comma-separated; o1 models may reject it")
+	seed := flag.Int64("seed", 0, "ask for reproducible sampling with this seed, sent only when set")
+	maxTokens := flag.Int64("max-tokens", 0, "cap on completion tokens, reasoning included; 0 means no limit")
+	effort := flag.String("effort", "", "reasoning effort for o1 models: "+strings.Join(reasoningEfforts, ", ")+"; the server default applies when unset")
+	apiKey := flag.String("api-key", "", "OpenAI API key, overriding OPENAI_API_KEY (which is safer, as flags show up in ps)")
+	org := flag.String("org", os.Getenv("OPENAI_ORG_ID"), "organization to bill the request to (default $OPENAI_ORG_ID)")
+	project := flag.String("project", os.Getenv("OPENAI_PROJECT_ID"), "project to bill the request to (default $OPENAI_PROJECT_ID)")
+	baseURL := flag.String("base-url", os.Getenv("OPENAI_BASE_URL"), "API endpoint for OpenAI-compatible proxies and gateways (default $OPENAI_BASE_URL)")
+	configPath := flag.String("config", "", "read defaults from this file instead of config.json in the user config directory")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	completionShell := flag.String("completion", "", "print a completion script for bash, zsh or fish")
+	flag.Usage = usage
+	if err := parseArgs(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+	if *completionShell != "" {
+		if err := writeCompletion(os.Stdout, *completionShell); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		return
+	}
+
+	if !slices.Contains(logFormats, *logFormat) {
+		fmt.Fprintf(os.Stderr, "invalid -log-format %q, accepted values: %s\n", *logFormat, strings.Join(logFormats, ", "))
+		os.Exit(exitUsage)
+	}
+	if !slices.Contains(colorModes, *color) {
+		fmt.Fprintf(os.Stderr, "invalid -color %q, accepted values: %s\n", *color, strings.Join(colorModes, ", "))
+		os.Exit(exitUsage)
+	}
+	if *quiet && debug {
+		fmt.Fprintln(os.Stderr, "-quiet and -debug cannot be combined")
+		os.Exit(exitUsage)
+	}
+	if *quiet {
+		notices = io.Discard
+	}
+	logJSON := *logFormat == "json"
+	if logJSON {
+		useJSONLogging(*quiet)
+	}
+
+	// Settings from the config file fill in flags not given on the command
+	// line, so they are validated and take effect exactly like those flags.
+	isSet := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { isSet[f.Name] = true })
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v ", err)
+	}
+	for name, value := range cfg.flagValues() {
+		if isSet[name] || (name == "system" && isSet["system-file"]) {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid %s %q in config: %v\n", name, value, err)
+			os.Exit(exitUsage)
+		}
+		isSet[name] = true
+	}
+
+	// Optional request fields are sent only when set, since the o1 models
+	// reject most of them even at their default values.
+	var request openai.ChatCompletionNewParams
+	extra := map[string]any{}
+	if isSet["temperature"] {
+		request.Temperature = openai.F(*temperature)
+	}
+	if isSet["top-p"] {
+		request.TopP = openai.F(*topP)
+	}
+	if isSet["frequency-penalty"] {
+		request.FrequencyPenalty = openai.F(penalty("frequency-penalty", *frequencyPenalty))
+	}
+	if isSet["presence-penalty"] {
+		request.PresencePenalty = openai.F(penalty("presence-penalty", *presencePenalty))
+	}
+	if isSet["seed"] {
+		request.Seed = openai.F(*seed)
+	}
+	sequences, err := parseStops(stops)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -stop: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	if len(sequences) > 0 {
+		request.Stop = openai.F[openai.ChatCompletionNewParamsStopUnion](sequences)
+	}
+	if !slices.Contains(responseFormats, *responseFormat) {
+		fmt.Fprintf(os.Stderr, "invalid -response-format %q, accepted values: %s\n", *responseFormat, strings.Join(responseFormats, ", "))
+		os.Exit(exitUsage)
+	}
+	if *schemaPath != "" {
+		if isSet["response-format"] && *responseFormat != "json" {
+			fmt.Fprintf(os.Stderr, "-schema asks for JSON and cannot be combined with -response-format %s\n", *responseFormat)
+			os.Exit(exitUsage)
+		}
+		*responseFormat = "json"
+	}
+	if *responseFormat == "json" {
+		format, err := jsonResponseFormat(*schemaPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -schema: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		request.ResponseFormat = openai.F(format)
+	}
+	if len(logitBias) > 0 {
+		bias, err := parseLogitBias(logitBias)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -logit-bias: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		// The SDK only takes whole-number biases, so the field is set in
+		// the body where fractions like 0.5 survive.
+		extra["logit_bias"] = bias
+	}
+	if *choices < 1 {
+		fmt.Fprintf(os.Stderr, "invalid -n %d: must be positive\n", *choices)
+		os.Exit(exitUsage)
+	}
+	if *choices > 1 {
+		request.N = openai.F(*choices)
+	}
+	if *maxTokens < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -max-tokens %d: must be positive\n", *maxTokens)
+		os.Exit(exitUsage)
+	}
+	if *maxTokens > 0 {
+		request.MaxCompletionTokens = openai.F(*maxTokens)
+	}
+	if *effort != "" {
+		if !slices.Contains(reasoningEfforts, *effort) {
+			fmt.Fprintf(os.Stderr, "invalid -effort %q, accepted values: %s\n", *effort, strings.Join(reasoningEfforts, ", "))
+			os.Exit(exitUsage)
+		}
+		extra["reasoning_effort"] = *effort
+	}
+
+	if *timeout < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -timeout %v: must not be negative\n", *timeout)
+		os.Exit(exitUsage)
+	}
+	if *deadline < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -deadline %v: must not be negative\n", *deadline)
+		os.Exit(exitUsage)
+	}
+	// root bounds the whole run; each request gets its own -timeout within it.
+	root := context.Background()
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		root, cancel = context.WithTimeout(root, *deadline)
+		defer cancel()
+	}
+	if *retries < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -retries %d: must not be negative\n", *retries)
+		os.Exit(exitUsage)
+	}
+	if *maxBackoff <= 0 {
+		fmt.Fprintf(os.Stderr, "invalid -max-backoff %v: must be positive\n", *maxBackoff)
+		os.Exit(exitUsage)
+	}
+	if *warnTokens < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -warn-tokens %d: must not be negative\n", *warnTokens)
+		os.Exit(exitUsage)
+	}
+	if *jsonOut || *choices > 1 {
+		// The JSON object needs the finished response, and several choices
+		// would stream interleaved, so both always buffer.
+		*stream = false
+	}
+	model, err := resolveModel(*modelName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitUsage)
+	}
+	// Gateways behind -base-url name their models as they like.
+	if !isKnownModel(model) && *baseURL == "" {
+		inform(fmt.Sprintf("%s is not a model this tool knows, sending it as given", model), "model", model)
+	}
+	images, err := readImages(imagePaths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+	// Whether a model it does not know reads images is left to the API.
+	if len(images) > 0 && isKnownModel(model) && !slices.Contains(visionModels, model) {
+		if isSet["model"] {
+			fmt.Fprintf(os.Stderr, "%s cannot read images; use -image with one of: %s\n", model, strings.Join(visionModels, ", "))
+			os.Exit(exitUsage)
+		}
+		inform(fmt.Sprintf("%s cannot read images, using %s instead", model, visionModels[0]), "model", visionModels[0])
+		model = visionModels[0]
+	}
+
+	system, err := loadSystemPrompt(*systemPrompt, *systemFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitUsage)
+	}
+	// replyFile is the stream replies are printed to, or nil with -o.
+	replyFile := os.Stdout
+	if outputPath != "" {
+		replyFile = nil
+	}
+	jsonReply := request.ResponseFormat.Present
+	opts := &options{
+		model:      model,
+		system:     system,
+		request:    request,
+		extra:      extra,
+		timeout:    *timeout,
+		retries:    *retries,
+		maxBackoff: *maxBackoff,
+		images:     images,
+		stream:     *stream,
+		json:       *jsonOut,
+		usage:      *showUsage,
+		// Escape codes would corrupt JSON strings, and stripping markdown
+		// would alter a JSON reply; -color decides the rest.
+		highlight: *highlight && !*plain && !*jsonOut && !jsonReply && useColor(*color, replyFile),
+		plain:     *plain && !*jsonOut && !jsonReply,
+		trim:      *trim,
+		out:       os.Stdout,
+		// Streamed text shows progress by itself, and a spinner would
+		// garble the -debug log or output that is not a terminal.
+		spinner:        !*stream && !*jsonOut && !debug && !*quiet && !logJSON && isTerminal(os.Stdout) && isTerminal(os.Stderr) && useColor(*color, os.Stderr),
+		fingerprint:    *showUsage || debug,
+		timing:         *timing,
+		logJSON:        logJSON,
+		systemRejected: new(atomic.Bool),
+	}
+	var endpoint string
+	if *baseURL != "" {
+		endpoint, err = normalizeBaseURL(*baseURL)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if *useCache && !*noCache {
+		if *cacheTTL < 0 {
+			fmt.Fprintf(os.Stderr, "invalid -cache-ttl %v: must not be negative\n", *cacheTTL)
+			os.Exit(exitUsage)
+		}
+		opts.cache, err = newResponseCache(*cacheTTL, cacheScope(endpoint, *org, *project))
+		if err != nil {
+			log.Fatalf("Failed to find the cache directory: %v ", err)
+		}
+	}
+	if outputPath != "" {
+		// Check up front so an existing file is not found only after the
+		// tokens have been paid for.
+		if _, err := os.Stat(outputPath); err == nil && !*force {
+			fmt.Fprintf(os.Stderr, "%s already exists; pass -force to overwrite it\n", outputPath)
+			os.Exit(exitUsage)
+		}
+		file := &fileOutput{path: outputPath, force: *force}
+		defer file.Close()
+		opts.out = file
+	}
+
+	if *continueLast {
+		if *sessionName != "" {
+			fmt.Fprintln(os.Stderr, "-continue picks up the last conversation and cannot be combined with -session")
+			os.Exit(exitUsage)
+		}
+		*sessionName, err = recentSession()
+		if err != nil {
+			log.Fatalf("Failed to find the last conversation: %v ", err)
+		}
+	}
+	if *reset && *sessionName == "" {
+		fmt.Fprintln(os.Stderr, "-reset needs -session")
+		os.Exit(exitUsage)
+	}
+	var history []chatMessage
+	if *reset {
+		if err := resetSession(*sessionName); err != nil {
+			log.Fatalf("Failed to reset session: %v ", err)
+		}
+	} else if *sessionName != "" {
+		history, err = loadSession(*sessionName)
+		if err != nil {
+			log.Fatalf("Failed to load session: %v ", err)
+		}
+		if *continueLast && len(history) == 0 {
+			inform("no previous conversation to continue, starting a new one")
+		}
+	}
+	if *sessionImport != "" {
+		imported, err := importMessages(*sessionImport)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to import session: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		history = append(history, imported...)
+	}
+
+	// Every flag is checked before the API key, so bad usage is reported
+	// as such even where no key is set.
+	if *watchPath != "" {
+		if *chat || *batchPath != "" || *sessionName != "" || *edit || *dryRun || outputPath != "" || flag.NArg() > 1 {
+			fmt.Fprintln(os.Stderr, "-watch sends the query argument with the watched file as input and cannot be combined with -chat, -batch, -session, -edit, -dry-run, -o or more files")
+			os.Exit(exitUsage)
+		}
+		if *watchInterval <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid -watch-interval %v: must be positive\n", *watchInterval)
+			os.Exit(exitUsage)
+		}
+	}
+	if *chat {
+		if flag.NArg() > 0 {
+			fmt.Fprintln(os.Stderr, "-chat reads its turns from stdin and takes no query argument")
+			os.Exit(exitUsage)
+		}
+		if len(images) > 0 {
+			fmt.Fprintln(os.Stderr, "-image attaches to a single query and cannot be combined with -chat")
+			os.Exit(exitUsage)
+		}
+		// Each turn is sent as it is typed, so there is no request to print.
+		if *dryRun {
+			fmt.Fprintln(os.Stderr, "-dry-run prints a single request and cannot be combined with -chat")
+			os.Exit(exitUsage)
+		}
+	}
+	if *batchPath != "" {
+		if *chat || *sessionName != "" || *sessionImport != "" || *dryRun || flag.NArg() > 0 {
+			fmt.Fprintln(os.Stderr, "-batch sends each line as its own query and cannot be combined with -chat, -session, -session-import, -dry-run or a query argument")
+			os.Exit(exitUsage)
+		}
+		if *concurrency < 1 {
+			fmt.Fprintln(os.Stderr, "-concurrency must be at least 1")
+			os.Exit(exitUsage)
+		}
+	}
+	joiner := *sep
+	if (*raw || *prefix != "" || *lineNumbers) && !isSet["sep"] {
+		joiner = "\n"
+	}
+	if strings.ContainsAny(*prefix, "<> \t\n") {
+		fmt.Fprintf(os.Stderr, "invalid -prefix %q: must be a tag name without spaces or angle brackets\n", *prefix)
+		os.Exit(exitUsage)
+	}
+
+	var tmpl *template.Template
+	if *templatePath != "" {
+		if *chat || *batchPath != "" || *watchPath != "" {
+			fmt.Fprintln(os.Stderr, "-template builds a single query and cannot be combined with -chat, -batch or -watch")
+			os.Exit(exitUsage)
+		}
+		tmpl, err = loadTemplate(*templatePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -template: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+	if !*dryRun && *apiKey == "" && os.Getenv("OPENAI_API_KEY") == "" {
+		fmt.Fprintln(os.Stderr, "No OpenAI API key found. Set one with\n\n\texport OPENAI_API_KEY=sk-...\n\nor pass it with -api-key. Keys are listed at https://platform.openai.com/api-keys.")
+		os.Exit(exitAuth)
+	}
+	// Retries are handled by completeWithRetry so -retries is the only policy.
+	clientOpts := []option.RequestOption{option.WithMaxRetries(0)}
+	if *apiKey != "" {
+		clientOpts = append(clientOpts, option.WithAPIKey(*apiKey))
+	}
+	// The SDK sends whatever OPENAI_ORG_ID and OPENAI_PROJECT_ID hold, even
+	// when empty, so the headers are dropped unless there is a value.
+	if *org != "" {
+		clientOpts = append(clientOpts, option.WithOrganization(*org))
+	} else {
+		clientOpts = append(clientOpts, option.WithHeaderDel("OpenAI-Organization"))
+	}
+	if *project != "" {
+		clientOpts = append(clientOpts, option.WithProject(*project))
+	} else {
+		clientOpts = append(clientOpts, option.WithHeaderDel("OpenAI-Project"))
+	}
+	if endpoint != "" {
+		clientOpts = append(clientOpts, option.WithBaseURL(endpoint))
+	}
+	if debug {
+		clientOpts = append(clientOpts, option.WithMiddleware(debugMiddleware(os.Stderr)))
+	}
+	client := openai.NewClient(clientOpts...)
+	if *pingOnly {
+		elapsed, err := ping(root, client, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ping failed: %s\n", pingFailure(err, opts.model))
+			os.Exit(exitCode(err))
+		}
+		fmt.Printf("OK, %s answered in %v\n", opts.model, elapsed.Round(time.Millisecond))
+		return
+	}
+
+	// startPaging sends replies to the pager until the returned func is
+	// called. The pager is only started right before a query is sent so
+	// that no usage error leaves it waiting on an empty screen.
+	startPaging := func() (stop func()) {
+		if !*usePager || *jsonOut || *quiet || outputPath != "" || !isTerminal(os.Stdout) {
+			return func() {}
+		}
+		p, err := startPager()
+		if err != nil {
+			warn(fmt.Sprintf("could not start the pager: %v", err))
+			return func() {}
+		}
+		// The spinner would draw over the pager's screen, and notices
+		// wait until the user has quit it.
+		opts.out, opts.spinner = p, false
+		release := diagnostics.hold()
+		return func() {
+			p.Close()
+			opts.out = os.Stdout
+			release()
+		}
+	}
+
+	if *watchPath != "" {
+		clear := useColor(*color, os.Stdout)
+		sigCtx, stop := signal.NotifyContext(root, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err := watchFile(sigCtx, *watchPath, *watchInterval, func(ctx context.Context, content string) {
+			if *lineNumbers {
+				content = numberLines(content)
+			}
+			if clear {
+				fmt.Print(clearScreen)
+			}
+			query := buildQuery(flag.Arg(0), wrapInput(content, *prefix), joiner)
+			if query == "" {
+				fmt.Fprintf(os.Stderr, "%s is empty, nothing sent\n", *watchPath)
+				return
+			}
+			_, err := send(ctx, client, opts, history, query)
+			// A query cancelled by the next change needs no report.
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			if msg := explainError(err, opts.model); msg != "" {
+				fmt.Fprintln(os.Stderr, msg)
+			} else {
+				fmt.Fprintf(os.Stderr, "Failed to get chat completion: %v\n", err)
+			}
+		})
+		if err != nil {
+			log.Fatalf("Failed to watch %s: %v ", *watchPath, err)
+		}
+		return
+	}
+	if *chat {
+		sigCtx, stop := signal.NotifyContext(root, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if *sessionName != "" {
+			rememberSession(*sessionName)
+		}
+		if err := runChat(sigCtx, client, opts, *sessionName, history); err != nil {
+			exitIfInterrupted(sigCtx)
+			log.Printf("Chat failed: %v ", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if *batchPath != "" {
+		prompts, err := readBatch(*batchPath)
+		if err != nil {
+			log.Fatalf("Failed to read batch file: %v ", err)
+		}
+		sigCtx, stop := signal.NotifyContext(root, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		stopPaging := startPaging()
+		err = runBatch(sigCtx, client, opts, prompts, *concurrency)
+		stopPaging()
+		if err != nil {
+			log.Printf("Batch failed: %v ", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	// A template is given the positional arguments instead of files.
+	var files string
+	if flag.NArg() > 1 && tmpl == nil {
+		files, err = readFiles(flag.Args()[1:])
+		if err != nil {
+			log.Fatalf("Failed to read files: %v ", err)
+		}
+	}
+	input := readInput(*maxInput, *truncate, *forceBinary)
+	if *lineNumbers {
+		input = numberLines(input)
+	}
+	if files != "" {
+		if input != "" {
+			files += "=== stdin ===\n" + input
+		}
+		input = files
+	}
+	// Only the piped and file input is data; a query written in the
+	// editor is an instruction and stays outside the -prefix block.
+	input = wrapInput(input, *prefix)
+	if *edit {
+		edited, err := editPrompt()
+		if err != nil {
+			log.Fatalf("Failed to edit the query: %v ", err)
+		}
+		edited = strings.TrimSpace(edited)
+		if edited == "" {
+			fmt.Fprintln(os.Stderr, "empty query, nothing sent")
+			os.Exit(exitUsage)
+		}
+		input = buildQuery(edited, input, "\n\n")
+	}
+	if *reset && flag.NArg() < 1 && input == "" {
+		return
+	}
+	if flag.NArg() < 1 && input == "" && tmpl == nil {
 		println("Usage: send a query to 01 via typing something or cat a file")
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+
+	query := buildQuery(flag.Arg(0), input, joiner)
+	if tmpl != nil {
+		query, err = renderTemplate(tmpl, flag.Args(), input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-template failed: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		if strings.TrimSpace(query) == "" {
+			fmt.Fprintf(os.Stderr, "%s rendered an empty query, nothing sent\n", *templatePath)
+			os.Exit(exitUsage)
+		}
+	}
+	if *estimate || *showUsage || *warnTokens > 0 {
+		tokens, err := promptTokens(opts.system, history, query)
+		if err != nil {
+			log.Fatalf("Failed to count prompt tokens: %v ", err)
+		}
+		if *estimate || *showUsage {
+			inform(fmt.Sprintf("estimated prompt=%d", tokens), "model", opts.model, "prompt_tokens", tokens)
+		}
+		if !*dryRun && *warnTokens > 0 && tokens > *warnTokens && !*yes {
+			fmt.Fprintf(os.Stderr, "the prompt is about %d tokens, more than -warn-tokens %d\n", tokens, *warnTokens)
+			if !confirmSend(tokens) {
+				fmt.Fprintln(os.Stderr, "not sent; pass -yes to send it without asking")
+				os.Exit(exitUsage)
+			}
+		}
+	}
+	if *dryRun {
+		if err := writeDryRun(os.Stdout, newParams(opts, history, query), opts.extra, opts.stream); err != nil {
+			log.Fatalf("Failed to write request: %v ", err)
+		}
+		return
+	}
+	// Only trap signals once stdin has been read so Ctrl-C still aborts a
+	// stuck pipe the usual way.
+	sigCtx, stop := signal.NotifyContext(root, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopPaging := startPaging()
+	chatCompletion, err := send(sigCtx, client, opts, history, query)
+	stopPaging()
+	if err != nil {
+		exitIfInterrupted(sigCtx)
+		if msg := explainError(err, opts.model); msg != "" {
+			fmt.Fprintln(os.Stderr, msg)
+			os.Exit(exitCode(err))
+		}
+		log.Printf("Failed to get chat completion: %v ", err)
+		os.Exit(exitCode(err))
+	}
+	history = appendTurn(history, query, chatCompletion)
+	if *sessionName != "" {
+		if err := saveSession(*sessionName, history); err != nil {
+			log.Fatalf("Failed to save session: %v ", err)
+		}
+	} else if err := saveSession(lastSession, history); err != nil {
+		// Failing to keep an unnamed conversation only costs a later
+		// -continue its context.
+		warn(fmt.Sprintf("could not keep the conversation for -continue: %v", err))
+		return
+	}
+	rememberSession(*sessionName)
+}
+
+// rememberSession records the session a query was kept in as the one for
+// -continue to pick up; an empty name is lastSession.
+func rememberSession(name string) {
+	if name == "" {
+		name = lastSession
+	}
+	if err := setRecentSession(name); err != nil {
+		warn(fmt.Sprintf("could not record the conversation for -continue: %v", err))
+	}
+}
+
+// penalty returns the value of a penalty flag, exiting if it is outside the
+// range the API accepts.
+func penalty(name string, value float64) float64 {
+	if value < -2 || value > 2 {
+		fmt.Fprintf(os.Stderr, "invalid -%s %v: must be between -2 and 2\n", name, value)
+		os.Exit(exitUsage)
+	}
+	return value
+}
+
+// parseStops splits the -stop values at commas into stop sequences, of which
+// the API accepts at most maxStops.
+func parseStops(values []string) (openai.ChatCompletionNewParamsStopArray, error) {
+	var sequences openai.ChatCompletionNewParamsStopArray
+	for _, v := range values {
+		for _, seq := range strings.Split(v, ",") {
+			if seq != "" {
+				sequences = append(sequences, seq)
+			}
+		}
+	}
+	if len(sequences) > maxStops {
+		return nil, fmt.Errorf("at most %d sequences are accepted, got %d", maxStops, len(sequences))
+	}
+	return sequences, nil
+}
+
+// parseLogitBias reads -logit-bias entries of comma-separated TOKEN:BIAS
+// pairs into the map the API takes, keyed by token ID.
+func parseLogitBias(entries []string) (map[string]float64, error) {
+	bias := map[string]float64{}
+	for _, entry := range entries {
+		for _, pair := range strings.Split(entry, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			token, value, ok := strings.Cut(pair, ":")
+			if !ok {
+				return nil, fmt.Errorf("%q is not TOKEN:BIAS", pair)
+			}
+			id, err := strconv.ParseUint(strings.TrimSpace(token), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%q: token must be a token ID", pair)
+			}
+			b, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || !(b >= -100 && b <= 100) {
+				return nil, fmt.Errorf("%q: bias must be a number from -100 to 100", pair)
+			}
+			key := strconv.FormatUint(id, 10)
+			if _, dup := bias[key]; dup {
+				return nil, fmt.Errorf("token %s is given more than once", key)
+			}
+			bias[key] = b
+		}
+	}
+	return bias, nil
+}
+
+// buildQuery joins the query argument and the file or piped input into the
+// user message with sep between them. Either may be empty, so piped input
+// alone makes a full prompt.
+func buildQuery(arg, input, sep string) string {
+	switch {
+	case arg == "":
+		return input
+	case input == "":
+		return arg
 	}
+	return arg + sep + input
+}
+
+// wrapInput sets the input off from the query in a <prefix> block; an
+// empty prefix leaves it as it is.
+func wrapInput(input, prefix string) string {
+	if prefix == "" || input == "" {
+		return input
+	}
+	return "<" + prefix + ">\n" + strings.TrimSuffix(input, "\n") + "\n</" + prefix + ">"
+}
 
-	client := openai.NewClient()
-	input := readInput()
-	query := os.Args[1]
-	if len(input) > 0 {
-		query = query + ": " + input
+// loadSystemPrompt returns the system instruction given by -system or read
+// from -system-file; at most one of the two may be set.
+func loadSystemPrompt(text, path string) (string, error) {
+	if path == "" {
+		return text, nil
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-	chatCompletion, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(os.Args[1] + ": " + input),
-		}),
-		Model: openai.F(openai.ChatModelO1Mini),
-	})
+	if text != "" {
+		return "", errors.New("-system and -system-file cannot be used together")
+	}
+	b, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("Failed to get chat completion: %v ", err)
+		return "", fmt.Errorf("reading system prompt: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// normalizeBaseURL checks that raw is an absolute http or https URL and ends
+// it with the single slash the SDK needs to resolve endpoints beneath it.
+func normalizeBaseURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid base URL %q: must be an absolute http or https URL", raw)
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/"
+	return u.String(), nil
+}
+
+// requestContext returns the context bounding a request; a zero timeout
+// means the request may run for as long as the model needs.
+func requestContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(parent)
 	}
-	if len(chatCompletion.Choices) == 0 {
-		log.Fatal("No completions returned")
+	return context.WithTimeout(parent, timeout)
+}
+
+// exitIfInterrupted exits with exitTimeout when ctx was cancelled by Ctrl-C
+// or SIGTERM, or ran out at the -deadline.
+func exitIfInterrupted(ctx context.Context) {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		fmt.Fprintln(os.Stderr, "-deadline reached")
+		os.Exit(exitTimeout)
+	case ctx.Err() != nil:
+		fmt.Fprintln(os.Stderr, "cancelled")
+		os.Exit(exitTimeout)
 	}
-	fmt.Println(chatCompletion.Choices[0].Message.Content)
 }
 
-func readInput() string {
+// readInput returns piped stdin, reading at most maxInput bytes unless
+// maxInput is 0. Longer input is cut short with a warning when truncate is
+// set and is an error otherwise. Input that looks binary is refused unless
+// forceBinary is set, since it would only waste a request; text has its
+// terminal escapes and other control characters stripped.
+func readInput(maxInput int64, truncate, forceBinary bool) string {
 	info, err := os.Stdin.Stat()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error checking stdin: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFailure)
 	}
 	// Check if stdin is a pipe or a regular file
 	if info.Mode()&os.ModeCharDevice == 0 {
-		reader := bufio.NewReader(os.Stdin)
-		// lineNumber := 1
+		var reader io.Reader = bufio.NewReader(os.Stdin)
+		if maxInput > 0 {
+			// One byte past the limit tells a full read from an overlong one.
+			reader = io.LimitReader(reader, maxInput+1)
+		}
 		b, err := io.ReadAll(reader)
 		if err != nil {
 			println("Error reading input")
-			os.Exit(1)
+			os.Exit(exitFailure)
+		}
+		if maxInput > 0 && int64(len(b)) > maxInput {
+			if !truncate {
+				fmt.Fprintf(os.Stderr, "Input is larger than -max-input (%d bytes); raise the limit or pass -truncate\n", maxInput)
+				os.Exit(exitUsage)
+			}
+			// Cut at a rune boundary so the prompt stays valid UTF-8.
+			cut := int(maxInput)
+			for cut > 0 && !utf8.RuneStart(b[cut]) {
+				cut--
+			}
+			b = b[:cut]
+			warn(fmt.Sprintf("input truncated to %d bytes", cut), "bytes", cut)
 		}
-		return string(b)
+		if reason := binaryReason(b); reason != "" {
+			if !forceBinary {
+				fmt.Fprintf(os.Stderr, "Input looks binary (%s); pipe in text or pass -force-binary to send it anyway\n", reason)
+				os.Exit(exitBinary)
+			}
+			return sanitizeBinary(b)
+		}
+		return stripControls(string(b))
 	}
 	return ""
 }
+
+// numberLines prefixes each line of text with its 1-based number, right-
+// aligned to the width of the last. A final newline ends the last line
+// rather than starting another, and is kept only if text had one.
+func numberLines(text string) string {
+	if text == "" {
+		return ""
+	}
+	body, newline := strings.CutSuffix(text, "\n")
+	lines := strings.Split(body, "\n")
+	width := len(strconv.Itoa(len(lines)))
+	var b strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "%*d: %s", width, i+1, line)
+	}
+	if newline {
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// binarySniffLen is how much of the input is searched for NUL bytes, as
+// git and diff do to tell binary files from text.
+const binarySniffLen = 8000
+
+// binaryReason says why b does not look like text, or returns "" if it does.
+func binaryReason(b []byte) string {
+	if bytes.IndexByte(b[:min(len(b), binarySniffLen)], 0) >= 0 {
+		return "it contains NUL bytes"
+	}
+	if !utf8.Valid(b) {
+		return "it is not valid UTF-8"
+	}
+	return ""
+}
+
+// terminalEscape matches the escape sequences that color and move the
+// cursor in terminal output, such as the colors of piped logs.
+var terminalEscape = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-Z\\-_])`)
+
+// stripControls removes terminal escape sequences and any other control
+// characters but tabs and line breaks from text. The SDK quotes strings
+// Go's way, and its escapes for control characters are not valid JSON.
+func stripControls(text string) string {
+	text = terminalEscape.ReplaceAllString(text, "")
+	return strings.Map(func(r rune) rune {
+		if (r < 0x20 && r != '\t' && r != '\n' && r != '\r') || r == 0x7f {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// sanitizeBinary makes binary input sendable by replacing invalid UTF-8 and
+// control characters other than whitespace with U+FFFD. The SDK quotes
+// strings Go's way, and its escapes for those are not valid JSON.
+func sanitizeBinary(b []byte) string {
+	return strings.Map(func(r rune) rune {
+		if (r < 0x20 && r != '\t' && r != '\n' && r != '\r') || r == 0x7f {
+			return utf8.RuneError
+		}
+		return r
+	}, strings.ToValidUTF8(string(b), string(utf8.RuneError)))
+}
+
+// readFiles concatenates the named files for the prompt, each headed by an
+// "=== name ===" line so the model can tell them apart. Directories are
+// skipped with a warning; any other file that cannot be read is an error.
+func readFiles(paths []string) (string, error) {
+	var b strings.Builder
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		if info.IsDir() {
+			inform("skipping directory "+path, "path", path)
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "=== %s ===\n%s", path, stripControls(string(content)))
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String(), nil
+}
+
+// stringList is a flag that may be given more than once, collecting every
+// value in order.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// isTerminal reports whether f is attached to a terminal rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
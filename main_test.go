@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+func TestParseStops(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   []string
+	}{
+		{nil, nil},
+		{[]string{"END"}, []string{"END"}},
+		{[]string{"a,b", "c"}, []string{"a", "b", "c"}},
+		{[]string{",a,,b,"}, []string{"a", "b"}},
+		{[]string{"\n\n", "###"}, []string{"\n\n", "###"}},
+		{[]string{"a,b", "c,d"}, []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		got, err := parseStops(tt.values)
+		if err != nil {
+			t.Errorf("parseStops(%q) failed: %v", tt.values, err)
+			continue
+		}
+		if !slices.Equal([]string(got), tt.want) {
+			t.Errorf("parseStops(%q) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestParseStopsLimit(t *testing.T) {
+	if _, err := parseStops([]string{"a,b,c,d,e"}); err == nil {
+		t.Errorf("parseStops accepted %d sequences, want at most %d", maxStops+1, maxStops)
+	}
+	if _, err := parseStops([]string{"a,b", "c", "d", "e"}); err == nil {
+		t.Errorf("parseStops accepted %d sequences over repeated flags, want at most %d", maxStops+1, maxStops)
+	}
+}
+
+// stopServer answers like the API does when generation ended at a stop
+// sequence, and records the stop sequences each request asked for.
+func stopServer(t *testing.T, got *[]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stop   []string `json:"stop"`
+			Stream bool     `json:"stream"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		*got = req.Stop
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, `data: {"id":"x","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":"one two"},"finish_reason":null}]}`+"\n\n")
+			io.WriteString(w, `data: {"id":"x","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`+"\n\n")
+			io.WriteString(w, "data: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"x","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"one two"}}],"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSendFinishReasonStop(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			var sent []string
+			srv := stopServer(t, &sent)
+			client := openai.NewClient(
+				option.WithBaseURL(srv.URL+"/"),
+				option.WithAPIKey("test"),
+				option.WithMaxRetries(0),
+			)
+			stops, err := parseStops([]string{"three,four"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			opts := &options{
+				model:      openai.ChatModelGPT4o,
+				request:    openai.ChatCompletionNewParams{Stop: openai.F[openai.ChatCompletionNewParamsStopUnion](stops)},
+				timeout:    10 * time.Second,
+				maxBackoff: time.Second,
+				stream:     stream,
+				out:        &out,
+			}
+
+			chatCompletion, err := send(context.Background(), client, opts, nil, "count")
+			if err != nil {
+				t.Fatalf("send failed: %v", err)
+			}
+			if reason := chatCompletion.Choices[0].FinishReason; reason != openai.ChatCompletionChoicesFinishReasonStop {
+				t.Errorf("finish reason = %q, want %q", reason, openai.ChatCompletionChoicesFinishReasonStop)
+			}
+			if want := []string{"three", "four"}; !slices.Equal(sent, want) {
+				t.Errorf("request sent stop %q, want %q", sent, want)
+			}
+			if got := strings.TrimSpace(out.String()); got != "one two" {
+				t.Errorf("printed %q, want %q", got, "one two")
+			}
+		})
+	}
+}
+
+func TestTrimContent(t *testing.T) {
+	tests := []struct {
+		content, want string
+	}{
+		{"\n\nx\n\n\n\ny\n\n", "x\n\ny"},
+		{"x\n```\n\n\n\n\ny\n```\n\n\n\nz", "x\n```\n\n\n\n\ny\n```\n\nz"},
+		// The ``` line is code inside the ~~~ block, not a fence of its own.
+		{"x\n~~~\n```\n\n\n\n\ny\n~~~\n\n\n\nz", "x\n~~~\n```\n\n\n\n\ny\n~~~\n\nz"},
+		{"x\n````\n```\n\n\n\n\n````\nz", "x\n````\n```\n\n\n\n\n````\nz"},
+	}
+	for _, tt := range tests {
+		if got := trimContent(tt.content); got != tt.want {
+			t.Errorf("trimContent(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", nil},
+		{"  -model o1  -stream ", []string{"-model", "o1", "-stream"}},
+		{"-system 'be brief, really'", []string{"-system", "be brief, really"}},
+		{`-system "it's \"fine\" \$HOME"`, []string{"-system", `it's "fine" $HOME`}},
+		{`-sep "a\nb"`, []string{"-sep", `a\nb`}},
+		{`-system be\ brief`, []string{"-system", "be brief"}},
+		{"-model o1 \\\n-stream", []string{"-model", "o1", "-stream"}},
+		{"-system \"be\\\n brief\"", []string{"-system", "be brief"}},
+		{`-sep ''`, []string{"-sep", ""}},
+		{`a'b'"c"d`, []string{"abcd"}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.s)
+		if err != nil {
+			t.Errorf("splitArgs(%q) failed: %v", tt.s, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSplitArgsErrors(t *testing.T) {
+	for _, s := range []string{`-system 'open`, `-system "open`, `-system x\`} {
+		if got, err := splitArgs(s); err == nil {
+			t.Errorf("splitArgs(%q) = %q, want an error", s, got)
+		}
+	}
+}
+
+func TestRetryDelayLateAttempts(t *testing.T) {
+	const maxBackoff = 30 * time.Second
+	for attempt := range 70 {
+		got := retryDelay(errors.New("connection reset"), attempt, maxBackoff)
+		if got <= 0 || got > maxBackoff {
+			t.Errorf("retryDelay(attempt %d) = %v, want within (0, %v]", attempt, got, maxBackoff)
+		}
+	}
+}
+
+func TestHighlightTildeFence(t *testing.T) {
+	highlight := func(s string) string {
+		var b bytes.Buffer
+		h := newHighlighter(&b)
+		if _, err := h.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+	backticks := highlight("```go\nx := 1\n```\nafter\n")
+	tildes := highlight("~~~go\nx := 1\n~~~\nafter\n")
+	if want := strings.ReplaceAll(backticks, "```", "~~~"); tildes != want {
+		t.Errorf("~~~ block highlighted as %q, want %q", tildes, want)
+	}
+	// A ``` line inside a ~~~ block is code, and the block ends at ~~~.
+	got := highlight("~~~\n```\nx\n~~~\n`after`\n")
+	if !strings.HasSuffix(got, "\n~~~\n`after`\n") || strings.HasPrefix(got, "~~~\n```") {
+		t.Errorf("``` inside a ~~~ block highlighted as %q", got)
+	}
+}
+
+func TestPrefixWithEdit(t *testing.T) {
+	tests := []struct {
+		edited, input, prefix, want string
+	}{
+		{"summarize", "", "doc", "summarize"},
+		{"summarize", "data\n", "", "summarize\n\ndata\n"},
+		{"summarize", "data\n", "doc", "summarize\n\n<doc>\ndata\n</doc>"},
+	}
+	for _, tt := range tests {
+		// As main does: the -edit query stays outside the -prefix block.
+		if got := buildQuery(tt.edited, wrapInput(tt.input, tt.prefix), "\n\n"); got != tt.want {
+			t.Errorf("edited %q with input %q and -prefix %q = %q, want %q", tt.edited, tt.input, tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// modelAliases maps the short names accepted by -model to API model names.
+var modelAliases = map[string]openai.ChatModel{
+	"mini":    openai.ChatModelO1Mini,
+	"preview": openai.ChatModelO1Preview,
+	"o1":      "o1",
+}
+
+// knownModels lists the API model names the tool knows. Others are sent as
+// given, for gateways and models newer than this list.
+var knownModels = []openai.ChatModel{
+	"o1",
+	"o1-2024-12-17",
+	openai.ChatModelO1Mini,
+	openai.ChatModelO1Mini2024_09_12,
+	openai.ChatModelO1Preview,
+	openai.ChatModelO1Preview2024_09_12,
+	openai.ChatModelGPT4o,
+	openai.ChatModelGPT4oMini,
+}
+
+// visionModels lists the known models that accept images, the first being
+// the one switched to when -image is used without choosing a model.
+var visionModels = []openai.ChatModel{
+	"o1",
+	"o1-2024-12-17",
+	openai.ChatModelGPT4o,
+	openai.ChatModelGPT4oMini,
+}
+
+// reasoningEfforts lists the values accepted by -effort. This SDK version
+// has no ReasoningEffort param, so the field is set on the request body.
+var reasoningEfforts = []string{"low", "medium", "high"}
+
+// resolveModel turns a -model value into the model name sent to the API:
+// the model a shortcut stands for, or any other name as given.
+func resolveModel(name string) (openai.ChatModel, error) {
+	if model, ok := modelAliases[name]; ok {
+		return model, nil
+	}
+	if strings.TrimSpace(name) == "" || strings.ContainsAny(name, " \t\n") {
+		return "", fmt.Errorf("invalid model %q, known names: %s", name, strings.Join(modelNames(), ", "))
+	}
+	return name, nil
+}
+
+// isKnownModel reports whether model is one of knownModels.
+func isKnownModel(model openai.ChatModel) bool {
+	return slices.Contains(knownModels, model)
+}
+
+// modelNames returns every name accepted by -model, shortcuts first.
+func modelNames() []string {
+	names := make([]string, 0, len(modelAliases)+len(knownModels))
+	for alias := range modelAliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	for _, model := range knownModels {
+		if _, ok := modelAliases[model]; !ok {
+			names = append(names, model)
+		}
+	}
+	return names
+}
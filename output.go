@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/tidwall/sjson"
+)
+
+// outputWriter is what replies are printed through. Flush is called once a
+// reply is complete so that anything held back is written out.
+type outputWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// directOutput is an outputWriter that holds nothing back.
+type directOutput struct {
+	io.Writer
+}
+
+func (directOutput) Flush() error { return nil }
+
+// newOutput returns the writer for printing one reply to opts.out.
+func newOutput(opts *options) outputWriter {
+	if opts.plain {
+		return newPlainWriter(opts.out)
+	}
+	if opts.highlight {
+		return newHighlighter(opts.out)
+	}
+	return directOutput{opts.out}
+}
+
+// timedWriter records when the first bytes of a reply were written to it.
+type timedWriter struct {
+	io.Writer
+	first time.Time
+}
+
+func (w *timedWriter) Write(p []byte) (int, error) {
+	if w.first.IsZero() && len(p) > 0 {
+		w.first = time.Now()
+	}
+	return w.Writer.Write(p)
+}
+
+// fileOutput writes replies to the file named by -o. The file, and any
+// missing parent directories, are only created by the first write, so a
+// request that fails leaves no empty file behind.
+type fileOutput struct {
+	path  string
+	force bool // replace an existing file rather than fail
+	f     *os.File
+}
+
+func (o *fileOutput) Write(p []byte) (int, error) {
+	if o.f == nil {
+		if err := os.MkdirAll(filepath.Dir(o.path), 0o755); err != nil {
+			return 0, err
+		}
+		flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if o.force {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		f, err := os.OpenFile(o.path, flags, 0o644)
+		if err != nil {
+			return 0, err
+		}
+		o.f = f
+	}
+	return o.f.Write(p)
+}
+
+func (o *fileOutput) Close() error {
+	if o.f == nil {
+		return nil
+	}
+	return o.f.Close()
+}
+
+// jsonResponse is the object printed by -json. It is spelled out field by
+// field so the shape scripts depend on does not move with the SDK.
+type jsonResponse struct {
+	Model        string    `json:"model"`
+	Content      string    `json:"content"`
+	FinishReason string    `json:"finish_reason"`
+	Usage        jsonUsage `json:"usage"`
+}
+
+type jsonUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	ReasoningTokens  int64 `json:"reasoning_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+// writeJSON prints the first choice of chatCompletion as a jsonResponse,
+// or with all set every choice as an array of them.
+func writeJSON(w io.Writer, chatCompletion *openai.ChatCompletion, all bool) error {
+	usage := chatCompletion.Usage
+	responses := make([]jsonResponse, len(chatCompletion.Choices))
+	for i, choice := range chatCompletion.Choices {
+		responses[i] = jsonResponse{
+			Model:        chatCompletion.Model,
+			Content:      choice.Message.Content,
+			FinishReason: string(choice.FinishReason),
+			Usage: jsonUsage{
+				PromptTokens:     usage.PromptTokens,
+				CompletionTokens: usage.CompletionTokens,
+				ReasoningTokens:  usage.CompletionTokensDetails.ReasoningTokens,
+				TotalTokens:      usage.TotalTokens,
+			},
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if all {
+		return enc.Encode(responses)
+	}
+	return enc.Encode(responses[0])
+}
+
+// writeDryRun prints the body that would be posted for params as indented
+// JSON, including the fields set outside the SDK params.
+func writeDryRun(w io.Writer, params openai.ChatCompletionNewParams, extra map[string]any, stream bool) error {
+	body, err := requestBody(params, extra)
+	if err != nil {
+		return err
+	}
+	if stream {
+		if body, err = sjson.SetBytes(body, "stream", true); err != nil {
+			return err
+		}
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// requestBody returns the JSON body sent for params with the extra fields
+// set, as extraFieldOptions would set them.
+func requestBody(params openai.ChatCompletionNewParams, extra map[string]any) ([]byte, error) {
+	body, err := params.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range slices.Sorted(maps.Keys(extra)) {
+		if body, err = sjson.SetBytes(body, key, extra[key]); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// trimContent tidies the whitespace of a reply: blank lines before it and
+// whitespace after it are dropped, and runs of three or more blank lines
+// are collapsed to one. Lines inside code fences are left as written.
+func trimContent(content string) string {
+	lines := strings.Split(strings.TrimRight(content, " \t\r\n"), "\n")
+	var kept []string
+	blanks := 0
+	inCode := false
+	fence := "" // the opening fence of the current code block
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case inCode && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "":
+			inCode = false
+		case !inCode && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")):
+			inCode = true
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+		}
+		if trimmed != "" || inCode {
+			if blanks >= 3 {
+				blanks = 1
+			}
+			if len(kept) > 0 {
+				for range blanks {
+					kept = append(kept, "")
+				}
+			}
+			blanks = 0
+			kept = append(kept, line)
+			continue
+		}
+		blanks++
+	}
+	return strings.Join(kept, "\n")
+}
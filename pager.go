@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// defaultPager is run when $PAGER is not set; -R passes colors through.
+var defaultPager = []string{"less", "-R"}
+
+// pager is a running $PAGER that replies are written to.
+type pager struct {
+	cmd  *exec.Cmd
+	w    io.WriteCloser
+	quit bool // the pager exited before the reply was all written
+}
+
+// startPager runs $PAGER with its output on the terminal. Unless $LESS is
+// set, less is told to quit at once when the reply fits on one screen and
+// to leave it there, as git does, so short replies are not paged at all.
+func startPager() (*pager, error) {
+	command := strings.Fields(os.Getenv("PAGER"))
+	if len(command) == 0 {
+		command = defaultPager
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &pager{cmd: cmd, w: w}, nil
+}
+
+// Write passes p to the pager. Once the user has quit it the rest of the
+// reply is dropped rather than failing the request.
+func (p *pager) Write(b []byte) (int, error) {
+	if !p.quit {
+		if _, err := p.w.Write(b); err != nil {
+			p.quit = true
+		}
+	}
+	return len(b), nil
+}
+
+// Close ends the reply and waits for the user to quit the pager.
+func (p *pager) Close() error {
+	p.w.Close()
+	return p.cmd.Wait()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// ping checks that the API answers with the configured key and endpoint by
+// looking up the model, which costs nothing, and returns how long that took.
+func ping(ctx context.Context, client *openai.Client, opts *options) (time.Duration, error) {
+	ctx, cancel := requestContext(ctx, opts.timeout)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Models.Get(ctx, string(opts.model))
+	return time.Since(start), err
+}
+
+// pingFailure says why a ping failed, telling a rejected key from an
+// endpoint that could not be reached.
+func pingFailure(err error, model openai.ChatModel) string {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		detail := apiErrorDetail(apiErr)
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return "authentication failed: " + detail.Message
+		case http.StatusNotFound:
+			return fmt.Sprintf("%s is not available with this key: %s", model, detail.Message)
+		}
+		return fmt.Sprintf("the API answered %d %s: %s", apiErr.StatusCode, http.StatusText(apiErr.StatusCode), detail.Message)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timed out waiting for the API"
+	}
+	return "could not reach the API: " + err.Error()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// plainWriter strips the markdown decoration from a reply for readers that
+// want plain text: heading marks, bold and italic markers, list bullets and
+// the fences and backticks around code, whose contents are kept. Each line
+// is rewritten once it is complete, so a streamed reply arrives a line at a
+// time.
+type plainWriter struct {
+	w      io.Writer
+	line   []byte // the current line, not yet ended by a newline
+	inCode bool
+	fence  string // the opening fence of the current code block
+}
+
+func newPlainWriter(w io.Writer) *plainWriter {
+	return &plainWriter{w: w}
+}
+
+func (p *plainWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			p.line = append(p.line, b...)
+			return n, nil
+		}
+		p.line = append(p.line, b[:i+1]...)
+		b = b[i+1:]
+		if err := p.endLine(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// endLine writes a complete line, stripped unless it is inside a code block.
+// Fence lines themselves are dropped.
+func (p *plainWriter) endLine() error {
+	line := string(p.line)
+	p.line = p.line[:0]
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case p.inCode && strings.HasPrefix(trimmed, p.fence) && strings.Trim(trimmed, p.fence[:1]) == "":
+		p.inCode = false
+		return nil
+	case p.inCode:
+		_, err := io.WriteString(p.w, line)
+		return err
+	case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+		p.inCode = true
+		p.fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+		return nil
+	}
+	text, newline := strings.CutSuffix(line, "\n")
+	text = stripMarkdown(text)
+	if newline {
+		text += "\n"
+	}
+	_, err := io.WriteString(p.w, text)
+	return err
+}
+
+// Flush writes the last line when the reply did not end with a newline.
+func (p *plainWriter) Flush() error {
+	if len(p.line) == 0 {
+		return nil
+	}
+	return p.endLine()
+}
+
+var (
+	headingMark    = regexp.MustCompile(`^ {0,3}#{1,6}\s+`)
+	listBullet     = regexp.MustCompile(`^(\s*)[-*+]\s+(\S)`)
+	horizontalRule = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	headingClose   = regexp.MustCompile(`\s+#+\s*$`)
+	inlineCode     = regexp.MustCompile("`([^`]+)`")
+
+	// Emphasis markers must hug the text they wrap and sit at word
+	// boundaries, so arithmetic like 2 * 3 * 4 survives. Underscores are
+	// left alone: in prose they are far more often part of names like
+	// __init__ than emphasis.
+	emphasisMarks = []*regexp.Regexp{
+		regexp.MustCompile(`(^|[^\w*])\*\*([^\s*](?:[^*]*[^\s*])?)\*\*($|[^\w*])`),
+		regexp.MustCompile(`(^|[^\w*])\*([^\s*](?:[^*]*[^\s*])?)\*($|[^\w*])`),
+	}
+)
+
+// stripMarkdown removes the markdown decoration from one line of prose.
+// Text inside inline code is kept as written, without its backticks.
+func stripMarkdown(line string) string {
+	if horizontalRule.MatchString(line) {
+		return line
+	}
+	if headingMark.MatchString(line) {
+		line = headingMark.ReplaceAllString(line, "")
+		line = headingClose.ReplaceAllString(line, "")
+	}
+	line = listBullet.ReplaceAllString(line, "$1$2")
+
+	var b strings.Builder
+	last := 0
+	for _, span := range inlineCode.FindAllStringSubmatchIndex(line, -1) {
+		b.WriteString(stripEmphasis(line[last:span[0]]))
+		b.WriteString(line[span[2]:span[3]])
+		last = span[1]
+	}
+	b.WriteString(stripEmphasis(line[last:]))
+	return b.String()
+}
+
+// stripEmphasis removes bold and italic markers from text.
+func stripEmphasis(text string) string {
+	for _, re := range emphasisMarks {
+		// A match takes the character after its closing marker, which may
+		// be where the next one starts, so repeat until nothing changes.
+		for {
+			stripped := re.ReplaceAllString(text, "$1$2$3")
+			if stripped == text {
+				break
+			}
+			text = stripped
+		}
+	}
+	return text
+}
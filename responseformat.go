@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// responseFormats lists the values -response-format accepts.
+var responseFormats = []string{"text", "json"}
+
+// schemaTypes lists the type names JSON Schema defines.
+var schemaTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// schemaNameInvalid matches the characters the API does not allow in the
+// name of a response schema.
+var schemaNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// jsonResponseFormat returns the response format asking for a JSON reply:
+// any JSON object, or with a schema path one that follows the schema in
+// the file, checked before it is sent.
+func jsonResponseFormat(schemaPath string) (openai.ChatCompletionNewParamsResponseFormatUnion, error) {
+	if schemaPath == "" {
+		return openai.ResponseFormatJSONObjectParam{
+			Type: openai.F(openai.ResponseFormatJSONObjectTypeJSONObject),
+		}, nil
+	}
+	b, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return nil, err
+	}
+	var schema any
+	if err := json.Unmarshal(b, &schema); err != nil {
+		return nil, fmt.Errorf("%s is not valid JSON: %w", schemaPath, err)
+	}
+	if err := checkSchema(schema, "schema"); err != nil {
+		return nil, fmt.Errorf("%s is not a valid JSON Schema: %w", schemaPath, err)
+	}
+	// The schema is named after its file, as the API requires a name.
+	name := strings.TrimSuffix(filepath.Base(schemaPath), filepath.Ext(schemaPath))
+	name = strings.Trim(schemaNameInvalid.ReplaceAllString(name, "_"), "_")
+	if name == "" {
+		name = "response"
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return openai.ResponseFormatJSONSchemaParam{
+		Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+		JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+			Name:   openai.F(name),
+			Schema: openai.F(schema),
+			Strict: openai.F(true),
+		}),
+	}, nil
+}
+
+// checkSchema reports the first keyword of a JSON Schema whose value has the
+// wrong shape, so a broken schema fails here rather than in the API. It
+// checks structure only; what the API supports in strict mode is left to
+// the API. at names the schema being checked, for the error.
+func checkSchema(v any, at string) error {
+	schema, ok := v.(map[string]any)
+	if !ok {
+		// true and false are schemas too: anything, and nothing.
+		if _, ok := v.(bool); ok {
+			return nil
+		}
+		return fmt.Errorf("%s must be an object", at)
+	}
+	switch t := schema["type"].(type) {
+	case nil:
+	case string:
+		if !slices.Contains(schemaTypes, t) {
+			return fmt.Errorf("%s.type %q is not one of %s", at, t, strings.Join(schemaTypes, ", "))
+		}
+	case []any:
+		for _, name := range t {
+			if s, ok := name.(string); !ok || !slices.Contains(schemaTypes, s) {
+				return fmt.Errorf("%s.type lists %v, which is not one of %s", at, name, strings.Join(schemaTypes, ", "))
+			}
+		}
+	default:
+		return fmt.Errorf("%s.type must be a type name or an array of them", at)
+	}
+	if required, ok := schema["required"]; ok {
+		names, ok := required.([]any)
+		if !ok {
+			return fmt.Errorf("%s.required must be an array of property names", at)
+		}
+		for _, name := range names {
+			if _, ok := name.(string); !ok {
+				return fmt.Errorf("%s.required must be an array of property names", at)
+			}
+		}
+	}
+	if enum, ok := schema["enum"]; ok {
+		if _, ok := enum.([]any); !ok {
+			return fmt.Errorf("%s.enum must be an array", at)
+		}
+	}
+	for _, key := range []string{"properties", "$defs", "definitions", "patternProperties"} {
+		sub, ok := schema[key]
+		if !ok {
+			continue
+		}
+		members, ok := sub.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s.%s must be an object of schemas", at, key)
+		}
+		for name, s := range members {
+			if err := checkSchema(s, at+"."+key+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range []string{"items", "additionalProperties", "not"} {
+		if sub, ok := schema[key]; ok {
+			if err := checkSchema(sub, at+"."+key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range []string{"anyOf", "allOf", "oneOf"} {
+		sub, ok := schema[key]
+		if !ok {
+			continue
+		}
+		list, ok := sub.([]any)
+		if !ok || len(list) == 0 {
+			return fmt.Errorf("%s.%s must be a non-empty array of schemas", at, key)
+		}
+		for i, s := range list {
+			if err := checkSchema(s, fmt.Sprintf("%s.%s[%d]", at, key, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkJSONReply reports a reply that is not the JSON a response format
+// asked for. The model can still break off or stray from the format, and
+// scripts relying on it should fail loudly rather than parse garbage. With
+// all set every choice is checked, not only the first.
+func checkJSONReply(chatCompletion *openai.ChatCompletion, all bool) error {
+	choices := chatCompletion.Choices
+	if !all {
+		choices = choices[:1]
+	}
+	for i, choice := range choices {
+		var v any
+		err := json.Unmarshal([]byte(choice.Message.Content), &v)
+		if err == nil {
+			continue
+		}
+		what := "the response"
+		if all {
+			what = fmt.Sprintf("choice %d", i+1)
+		}
+		if choice.FinishReason == openai.ChatCompletionChoicesFinishReasonLength {
+			return fmt.Errorf("%s is not valid JSON, as it was cut off at the completion token limit: %w", what, err)
+		}
+		return fmt.Errorf("%s is not valid JSON: %w", what, err)
+	}
+	return nil
+}
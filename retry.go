@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// completeWithRetry sends params, retrying rate limits and server errors up
+// to opts.retries times, and returns how many retries it took. Each attempt
+// gets its own opts.timeout. With opts.stream the reply is written to out as
+// it arrives.
+func completeWithRetry(ctx context.Context, client *openai.Client, opts *options, params openai.ChatCompletionNewParams, out io.Writer) (*openai.ChatCompletion, int, error) {
+	var stream io.Writer
+	if opts.stream {
+		stream = out
+	}
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := requestContext(ctx, opts.timeout)
+		// The spinner only runs during the request itself so retry notices
+		// are printed on a line of their own.
+		stopSpinner := func() {}
+		if opts.spinner {
+			stopSpinner = startSpinner(os.Stderr, start)
+		}
+		chatCompletion, err := complete(attemptCtx, client, params, stream, extraFieldOptions(opts.extra)...)
+		stopSpinner()
+		cancel()
+		if err == nil || attempt >= opts.retries || !isRetryable(err) {
+			return chatCompletion, attempt, err
+		}
+
+		delay := retryDelay(err, attempt, opts.maxBackoff)
+		warn(fmt.Sprintf("%v, retrying in %v (%d/%d)", retryReason(err), delay.Round(100*time.Millisecond), attempt+1, opts.retries),
+			"reason", retryReason(err), "delay_ms", delay.Milliseconds(), "attempt", attempt+1, "max_retries", opts.retries)
+		select {
+		case <-ctx.Done():
+			return nil, attempt, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isRetryable reports whether err is a failure that may clear up on its own.
+// Only errors the API answered with qualify: they arrive before any of a
+// streamed reply has been printed, so a retry never repeats output.
+func isRetryable(err error) bool {
+	var apiErr *openai.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch {
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		// An exhausted quota is reported as a rate limit but will not recover.
+		return apiErrorDetail(apiErr).Code != "insufficient_quota"
+	case apiErr.StatusCode == http.StatusRequestTimeout, apiErr.StatusCode == http.StatusConflict:
+		return true
+	}
+	return apiErr.StatusCode >= 500
+}
+
+// retryReason describes a retryable error in a few words for the retry notice.
+func retryReason(err error) string {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return fmt.Sprintf("%d %s", apiErr.StatusCode, http.StatusText(apiErr.StatusCode))
+	}
+	return err.Error()
+}
+
+// retryDelay returns how long to wait before retry number attempt+1: the
+// server's Retry-After when it sent one, otherwise exponential backoff from
+// one second with jitter. Either way the wait is capped at maxBackoff.
+func retryDelay(err error, attempt int, maxBackoff time.Duration) time.Duration {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && apiErr.Response != nil {
+		if d, ok := retryAfter(apiErr.Response.Header); ok {
+			return min(d, maxBackoff)
+		}
+	}
+	// A second shifted by 34 or more overflows, so late attempts go
+	// straight to the cap.
+	backoff := maxBackoff
+	if attempt < 30 && time.Second<<attempt < maxBackoff {
+		backoff = time.Second << attempt
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// retryAfter reads the wait the server asked for, given in milliseconds by
+// retry-after-ms or in seconds or as an HTTP date by Retry-After.
+func retryAfter(h http.Header) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(h.Get("Retry-After-Ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	v := h.Get("Retry-After")
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
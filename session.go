@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// chatMessage is one turn of a conversation, stored in the same role and
+// content shape the API uses for messages.
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// param converts m to the SDK message union. Roles are checked when a
+// conversation is loaded, so anything else here is an assistant turn.
+func (m chatMessage) param() openai.ChatCompletionMessageParamUnion {
+	switch m.Role {
+	case "system":
+		return openai.SystemMessage(m.Content)
+	case "user":
+		return openai.UserMessage(m.Content)
+	}
+	return openai.AssistantMessage(m.Content)
+}
+
+// validateMessages reports the first message whose role the tool cannot send.
+func validateMessages(messages []chatMessage) error {
+	for i, m := range messages {
+		switch m.Role {
+		case "system", "user", "assistant":
+		default:
+			return fmt.Errorf("message %d has unsupported role %q", i, m.Role)
+		}
+	}
+	return nil
+}
+
+// appendTurn records a finished exchange at the end of history.
+func appendTurn(history []chatMessage, query string, chatCompletion *openai.ChatCompletion) []chatMessage {
+	return append(history,
+		chatMessage{Role: "user", Content: query},
+		chatMessage{Role: "assistant", Content: chatCompletion.Choices[0].Message.Content},
+	)
+}
+
+// lastSession is the session a one-shot query without -session is kept
+// in, so -continue has something to follow up on.
+const lastSession = "last"
+
+// recentFile returns the file naming the session used most recently.
+func recentFile() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sessions", "recent"), nil
+}
+
+// recentSession returns the name of the session used most recently, for
+// -continue to pick up. Before any has been recorded that is lastSession.
+func recentSession() (string, error) {
+	path, err := recentFile()
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return lastSession, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(string(b))
+	if _, err := sessionPath(name); err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return name, nil
+}
+
+// setRecentSession records name as the session used most recently.
+func setRecentSession(name string) error {
+	path, err := recentFile()
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, []byte(name+"\n"))
+}
+
+// sessionPath returns the file a named session is kept in.
+func sessionPath(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid session name %q", name)
+	}
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sessions", name+".json"), nil
+}
+
+// loadSession reads the history of a named session. A session that has not
+// been saved yet has no history.
+func loadSession(name string) ([]chatMessage, error) {
+	path, err := sessionPath(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var messages []chatMessage
+	if err := json.Unmarshal(b, &messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validateMessages(messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return messages, nil
+}
+
+// importMessages reads a file of earlier turns to start a conversation
+// from, in the same format sessions are saved in: an array of messages,
+// each with a role and string content.
+func importMessages(path string) ([]chatMessage, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var messages []chatMessage
+	if err := json.Unmarshal(b, &messages); err != nil {
+		return nil, fmt.Errorf("%s: not an array of role and content messages: %w", path, err)
+	}
+	if err := validateMessages(messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return messages, nil
+}
+
+// saveSession replaces the stored history of a named session.
+func saveSession(name string, messages []chatMessage) error {
+	path, err := sessionPath(name)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, b)
+}
+
+// replaceFile writes b to a temporary file beside path and renames it over
+// path, so an interrupted write never leaves a half-written file behind and
+// queries saving at the same time never write into each other's.
+func replaceFile(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// resetSession deletes the stored history of a named session.
+func resetSession(name string) error {
+	path, err := sessionPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// hiddenFlags are left out of -h and of the completion scripts.
+var hiddenFlags = map[string]bool{"completion": true}
+
+// completionShells lists the shells -completion can write a script for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// fileFlags take a path, so the shells complete file names for them.
+var fileFlags = map[string]bool{"system-file": true, "config": true, "o": true, "output": true, "batch": true, "image": true, "session-import": true, "schema": true, "watch": true, "template": true}
+
+// usage prints the help shown by -h, leaving out hidden flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: o1 [flags] [query] [files...]\n\nSends the query, any files and piped stdin to an OpenAI model.\n\n")
+	visible := flag.NewFlagSet("o1", flag.ContinueOnError)
+	visible.SetOutput(out)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+			visible.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	visible.PrintDefaults()
+	fmt.Fprintf(out, "\nDefaults for any flag can be kept in $%s, quoted as in a shell, e.g. %s='-model o1 -timeout 5m'.\nA flag given on the command line overrides %s, which overrides the config file,\nwhich overrides the built-in default. Repeatable flags such as -image take values from both.\n",
+		envArgsVar, envArgsVar, envArgsVar)
+	fmt.Fprintf(out, "\nExit status: 0 on success, %d for bad usage, %d for a missing or rejected API key,\n%d when rate limited, %d on a timeout or Ctrl-C, %d for binary input and %d for any other failure.\n",
+		exitUsage, exitAuth, exitRateLimit, exitTimeout, exitBinary, exitFailure)
+}
+
+// completionFlag describes a flag for the completion scripts.
+type completionFlag struct {
+	name   string
+	usage  string
+	isBool bool
+	isFile bool
+	values []string // fixed set of accepted values, if any
+}
+
+// completionFlags returns the visible flags, sorted by name.
+func completionFlags() []completionFlag {
+	values := map[string][]string{
+		"model":           modelNames(),
+		"effort":          reasoningEfforts,
+		"color":           colorModes,
+		"log-format":      logFormats,
+		"response-format": responseFormats,
+	}
+	var flags []completionFlag
+	flag.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			name:   f.Name,
+			usage:  f.Usage,
+			isBool: ok && b.IsBoolFlag(),
+			isFile: fileFlags[f.Name],
+			values: values[f.Name],
+		})
+	})
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+	return flags
+}
+
+// writeCompletion prints a self-contained completion script for shell.
+func writeCompletion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, completionFlags())
+	case "zsh":
+		writeZshCompletion(w, completionFlags())
+	case "fish":
+		writeFishCompletion(w, completionFlags())
+	default:
+		return fmt.Errorf("unsupported shell %q, accepted: %s", shell, strings.Join(completionShells, ", "))
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, flags []completionFlag) {
+	var names []string
+	fmt.Fprintln(w, "# bash completion for o1; source it or install it in bash_completion.d")
+	fmt.Fprintln(w, "_o1() {")
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintln(w, `    case "$prev" in`)
+	for _, f := range flags {
+		names = append(names, "-"+f.name)
+		switch {
+		case f.values != nil:
+			fmt.Fprintf(w, "    -%[1]s|--%[1]s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", f.name, strings.Join(f.values, " "))
+		case f.isFile:
+			fmt.Fprintf(w, "    -%[1]s|--%[1]s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", f.name)
+		case !f.isBool:
+			fmt.Fprintf(w, "    -%[1]s|--%[1]s) return ;;\n", f.name)
+		}
+	}
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, `    if [[ "$cur" == -* ]]; then`)
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "    else")
+	fmt.Fprintln(w, `        COMPREPLY=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _o1 o1")
+}
+
+func writeZshCompletion(w io.Writer, flags []completionFlag) {
+	escape := strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`)
+	fmt.Fprintln(w, "#compdef o1")
+	fmt.Fprintln(w, "# zsh completion for o1; save it as _o1 in a directory on $fpath")
+	fmt.Fprintln(w, "_arguments \\")
+	for _, f := range flags {
+		spec := fmt.Sprintf("-%s[%s]", f.name, escape.Replace(f.usage))
+		switch {
+		case f.values != nil:
+			spec += fmt.Sprintf(":%s:(%s)", f.name, strings.Join(f.values, " "))
+		case f.isFile:
+			spec += fmt.Sprintf(":%s:_files", f.name)
+		case !f.isBool:
+			spec += fmt.Sprintf(":%s: ", f.name)
+		}
+		fmt.Fprintf(w, "  '%s' \\\n", spec)
+	}
+	fmt.Fprintln(w, "  '*:file:_files'")
+}
+
+func writeFishCompletion(w io.Writer, flags []completionFlag) {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	fmt.Fprintln(w, "# fish completion for o1; save it as ~/.config/fish/completions/o1.fish")
+	for _, f := range flags {
+		line := fmt.Sprintf("complete -c o1 -o %s", f.name)
+		switch {
+		case f.values != nil:
+			line += fmt.Sprintf(" -x -a '%s'", strings.Join(f.values, " "))
+		case f.isFile:
+			line += " -r -F"
+		case !f.isBool:
+			line += " -x"
+		}
+		fmt.Fprintf(w, "%s -d '%s'\n", line, quote.Replace(f.usage))
+	}
+}
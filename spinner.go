@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// spinnerFrames are drawn in turn while waiting for a buffered reply.
+var spinnerFrames = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// startSpinner draws a spinner and the time since start on w until the
+// returned function is called, which erases it before returning.
+func startSpinner(w io.Writer, start time.Time) (stop func()) {
+	done := make(chan struct{})
+	erased := make(chan struct{})
+	go func() {
+		defer close(erased)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprintf(w, "\r%c %v", spinnerFrames[i%len(spinnerFrames)], time.Since(start).Truncate(time.Second))
+			select {
+			case <-done:
+				fmt.Fprint(w, "\r\033[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-erased
+	}
+}
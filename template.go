@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// templateData is what a -template is executed with.
+type templateData struct {
+	Args  []string          // the positional arguments
+	Input string            // piped stdin
+	Env   map[string]string // the environment
+}
+
+// loadTemplate parses the prompt template in the file at path. Looking up
+// a missing key fails the execution rather than printing "<no value>"
+// into the prompt.
+func loadTemplate(path string) (*template.Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(path)).Option("missingkey=error").Parse(string(b))
+}
+
+// renderTemplate executes tmpl to build the query.
+func renderTemplate(tmpl *template.Template, args []string, input string) (string, error) {
+	data := templateData{Args: args, Input: input, Env: map[string]string{}}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			data.Env[k] = v
+		}
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
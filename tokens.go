@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tiktoken-go/tokenizer"
+)
+
+// Chat framing around the messages, as counted in OpenAI's cookbook: each
+// message costs a few tokens beyond its role and content, and every reply
+// is primed with a few more.
+const (
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+)
+
+// promptTokens estimates locally how many prompt tokens a query after
+// history is billed for. Every model -model accepts uses the o200k_base
+// encoding. Attached images are not counted.
+func promptTokens(system string, history []chatMessage, query string) (int, error) {
+	codec, err := tokenizer.Get(tokenizer.O200kBase)
+	if err != nil {
+		return 0, err
+	}
+	var messages []chatMessage
+	if system != "" {
+		messages = append(messages, chatMessage{Role: "system", Content: system})
+	}
+	messages = append(messages, history...)
+	messages = append(messages, chatMessage{Role: "user", Content: query})
+	total := tokensPerReply
+	for _, m := range messages {
+		for _, text := range []string{m.Role, m.Content} {
+			n, err := codec.Count(text)
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		}
+		total += tokensPerMessage
+	}
+	return total, nil
+}
+
+// confirmSend asks on the terminal whether to go ahead with a large prompt.
+// The terminal is opened directly since stdin usually holds the prompt
+// itself; without one there is no one to ask and the answer is no.
+func confirmSend(tokens int) bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+	fmt.Fprintf(tty, "send about %d prompt tokens? [y/N] ", tokens)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// modelPrice is the list price of a model in US dollars per million tokens.
+type modelPrice struct {
+	Prompt, Completion float64
+}
+
+// modelPrices backs the -usage cost estimate. Reasoning tokens are billed as
+// completion tokens, and both are already counted in CompletionTokens.
+var modelPrices = map[openai.ChatModel]modelPrice{
+	"o1":                                {15, 60},
+	"o1-2024-12-17":                     {15, 60},
+	openai.ChatModelO1Preview:           {15, 60},
+	openai.ChatModelO1Preview2024_09_12: {15, 60},
+	openai.ChatModelO1Mini:              {3, 12},
+	openai.ChatModelO1Mini2024_09_12:    {3, 12},
+	openai.ChatModelGPT4o:               {2.5, 10},
+	openai.ChatModelGPT4oMini:           {0.15, 0.6},
+}
+
+// printUsage writes a one-line token summary for usage to w, followed by a
+// cost estimate when the price of model is known. It prints nothing when the
+// API reported no usage.
+func printUsage(w io.Writer, model openai.ChatModel, usage openai.CompletionUsage) {
+	if usage.TotalTokens == 0 {
+		return
+	}
+	fmt.Fprintf(w, "prompt=%d completion=%d total=%d", usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+	if price, ok := modelPrices[model]; ok {
+		cost := (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6
+		fmt.Fprintf(w, " cost=$%.4f (estimate)", cost)
+	}
+	fmt.Fprintln(w)
+}
+
+// printTiming writes how long a reply took: until its first token arrived,
+// when it was streamed, and in total. Both count from when the first
+// attempt was sent, so retries are named as they add their wait.
+func printTiming(w io.Writer, firstToken, total time.Duration, retries int) {
+	if firstToken > 0 {
+		fmt.Fprintf(w, "first_token=%v ", firstToken.Round(time.Millisecond))
+	}
+	fmt.Fprintf(w, "total=%v", total.Round(time.Millisecond))
+	if retries > 0 {
+		fmt.Fprintf(w, " retries=%d", retries)
+	}
+	fmt.Fprintln(w)
+}
+
+// printFingerprint writes the backend configuration a reply came from. Not
+// every model reports one, and without it a -seed cannot be checked, which
+// is said only when a seed was sent.
+func printFingerprint(w io.Writer, fingerprint string, seeded bool) {
+	switch {
+	case fingerprint != "":
+		fmt.Fprintf(w, "system_fingerprint=%s\n", fingerprint)
+	case seeded:
+		fmt.Fprintln(w, "no system_fingerprint reported; the model may not honour -seed")
+	}
+}
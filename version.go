@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// version and commit identify a release build. They are set when building,
+// for example with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD)"
+//
+// and otherwise filled in from the build info Go embeds in the binary.
+var (
+	version string
+	commit  string
+)
+
+// versionString describes the running build for -version.
+func versionString() string {
+	v, c, goVersion := version, commit, runtime.Version()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		goVersion = info.GoVersion
+		if v == "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		dirty := false
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = s.Value
+				}
+			case "vcs.modified":
+				dirty = s.Value == "true"
+			}
+		}
+		if commit == "" && c != "" && dirty {
+			c += "-dirty"
+		}
+	}
+	if v == "" {
+		v = "devel"
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	return fmt.Sprintf("o1 %s (commit %s, %s)", v, c, goVersion)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// clearScreen moves the cursor home and clears the terminal.
+const clearScreen = "\033[H\033[2J"
+
+// watchFile calls run with the contents of the file at path, then again
+// each time the file changes, until ctx ends. Changes are found by polling
+// every interval, and one is acted on only once the file has stayed the
+// same for a whole interval, so an editor's burst of writes makes a single
+// run. A change that arrives while run is still going cancels it first.
+func watchFile(ctx context.Context, path string, interval time.Duration, run func(ctx context.Context, content string)) error {
+	last, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	cancel := func() {}
+	var done chan struct{}
+	stop := func() {
+		cancel()
+		if done != nil {
+			<-done
+		}
+	}
+	defer stop()
+	start := func() error {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		stop()
+		var runCtx context.Context
+		runCtx, cancel = context.WithCancel(ctx)
+		done = make(chan struct{})
+		go func() {
+			defer close(done)
+			run(runCtx, string(b))
+		}()
+		return nil
+	}
+	if err := start(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	changed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			// Editors that save by renaming leave no file for a moment.
+			continue
+		}
+		if !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+			last, changed = info, true
+			continue
+		}
+		if !changed {
+			continue
+		}
+		changed = false
+		if err := start(); err != nil {
+			warn(fmt.Sprintf("could not read %s: %v", path, err), "path", path)
+		}
+	}
+}